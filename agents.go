@@ -1,48 +1,37 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 
-	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
 	"github.com/prometheus/client_golang/prometheus"
 	logrus "github.com/sirupsen/logrus"
 	viper "github.com/spf13/viper"
 )
 
-type Agent struct {
-	ID           uint64 `json:"id"`
-	Name         string `json:"name"`
-	Authorized   bool   `json:"authorized"`
-	Connected    bool   `json:"connected"`
-	Enabled      bool   `json:"enabled"`
-	CurrentBuild Build  `json:"build"`
-}
-
-type AgentsResponse struct {
-	Count    uint64  `json:"count"`
-	HRef     string  `json:"href,omitempty"`
-	NextHRef string  `json:"nextHref,omitempty"`
-	PrevHRef string  `json:"prevHref,omitempty"`
-	Agents   []Agent `json:"agent"`
-}
-
 type TeamCityAgentCollector struct {
-	client *teamcity.Client
+	api    *tcapi.Client
+	server string
 
 	agentAuthorized     *prometheus.Desc
 	agentConnected      *prometheus.Desc
 	agentEnabled        *prometheus.Desc
 	agentCurrentBuildId *prometheus.Desc
+	agentBusy           *prometheus.Desc
+	agentCompatible     *prometheus.Desc
+	agentIncompatible   *prometheus.Desc
+
+	agentUnexpectedlyDisconnected  *prometheus.Desc
+	agentsUnexpectedlyDisconnected *prometheus.Desc
 }
 
-func NewTeamCityAgentCollector(client *teamcity.Client) *TeamCityAgentCollector {
+func NewTeamCityAgentCollector(server *Server) *TeamCityAgentCollector {
 	constLabels := prometheus.Labels{}
 
 	return &TeamCityAgentCollector{
-		client: client,
+		api:    server.API,
+		server: server.Name,
 
 		// Agent metrics descriptions.
 		agentAuthorized: prometheus.NewDesc(
@@ -72,6 +61,41 @@ func NewTeamCityAgentCollector(client *teamcity.Client) *TeamCityAgentCollector
 			[]string{"agent_id", "agent_name"},
 			constLabels,
 		),
+
+		agentBusy: prometheus.NewDesc(
+			"teamcity_agent_busy",
+			"Whether a TeamCity agent is currently running a build.",
+			[]string{"agent_id", "agent_name"},
+			constLabels,
+		),
+
+		agentCompatible: prometheus.NewDesc(
+			"teamcity_agent_compatible_build_types",
+			"The number of build types a TeamCity agent is compatible with.",
+			[]string{"agent_id", "agent_name"},
+			constLabels,
+		),
+
+		agentIncompatible: prometheus.NewDesc(
+			"teamcity_agent_incompatible_build_types",
+			"The number of build types a TeamCity agent is incompatible with.",
+			[]string{"agent_id", "agent_name"},
+			constLabels,
+		),
+
+		agentUnexpectedlyDisconnected: prometheus.NewDesc(
+			"teamcity_agent_unexpectedly_disconnected",
+			"Whether a TeamCity agent is enabled but not connected.",
+			[]string{"agent_id", "agent_name"},
+			constLabels,
+		),
+
+		agentsUnexpectedlyDisconnected: prometheus.NewDesc(
+			"teamcity_agents_unexpectedly_disconnected",
+			"The number of TeamCity agents that are enabled but not connected.",
+			[]string{},
+			constLabels,
+		),
 	}
 }
 
@@ -80,50 +104,41 @@ func (collector TeamCityAgentCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.agentConnected
 	ch <- collector.agentEnabled
 	ch <- collector.agentCurrentBuildId
+	ch <- collector.agentBusy
+	ch <- collector.agentCompatible
+	ch <- collector.agentIncompatible
+	ch <- collector.agentUnexpectedlyDisconnected
+	ch <- collector.agentsUnexpectedlyDisconnected
 }
 
 func (collector TeamCityAgentCollector) Collect(ch chan<- prometheus.Metric) {
 	logrus.Info("collecting TeamCity agent metrics")
 
-	url := fmt.Sprintf(
-		"%s/app/rest/agents?locator=count:%d&fields=count,nextHref,agent(id,name,authorized,connected,enabled,build(id))",
-		viper.GetString("addr"),
-		viper.GetUint("page.count"),
-	)
+	ctx, cancel := scrapeContext()
+	defer cancel()
 
-	client := collector.client.HTTPClient
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		logrus.Error(err)
-	}
-	request.Header.Set("Accept", "application/json")
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("token")))
-	response, err := client.Do(request)
+	err := collector.collectAgentMetrics(ctx, ch)
 	if err != nil {
 		logrus.Error(err)
+		countScrapeError(collector.server, "agents")
 	}
+}
 
-	if response.StatusCode == http.StatusNotFound {
-		return
-	}
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		logrus.Error(err)
+func (collector *TeamCityAgentCollector) collectAgentMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// TeamCity checks every build type against every agent to count the compatible ones, so only ask when enabled.
+	fields := "id,name,authorized,connected,enabled,build(id)"
+	if viper.GetBool("agents.compatibility") {
+		fields = fmt.Sprintf("%s,compatibleBuildTypes(count),incompatibleBuildTypes(count)", fields)
 	}
 
-	agents := AgentsResponse{}
-	err = json.Unmarshal(body, &agents)
+	locator := fmt.Sprintf("count:%d,connected:any,authorized:any", viper.GetUint("page.count"))
+	agents, err := collector.api.ListAgents(ctx, locator, fields)
 	if err != nil {
-		logrus.Error(err)
-	}
-
-	// Check for another request that we need to make to continue to get builds.
-	if agents.NextHRef != "" {
-		logrus.Fatal("multipage requests are not yet supported")
+		return err
 	}
 
-	for _, agent := range agents.Agents {
+	unexpectedlyDisconnected := 0
+	for _, agent := range agents {
 		labels := []string{fmt.Sprintf("%d", agent.ID), agent.Name}
 
 		// Set the agent authorized metric.
@@ -150,12 +165,61 @@ func (collector TeamCityAgentCollector) Collect(ch chan<- prometheus.Metric) {
 			labels...,
 		)
 
-		// Set the current build ID for the agent metric.
+		// Set the agent busy metric.
+		busy := agent.Busy()
 		ch <- prometheus.MustNewConstMetric(
-			collector.agentCurrentBuildId,
+			collector.agentBusy,
 			prometheus.GaugeValue,
-			float64(agent.CurrentBuild.ID),
+			float64(map[bool]int{true: 1, false: 0}[busy]),
+			labels...,
+		)
+
+		// Set the current build ID for the agent metric, idle agents report zero unless configured otherwise.
+		if busy || viper.GetBool("agents.idle_build_id") {
+			ch <- prometheus.MustNewConstMetric(
+				collector.agentCurrentBuildId,
+				prometheus.GaugeValue,
+				float64(agent.CurrentBuild.ID),
+				labels...,
+			)
+		}
+
+		// Set the agent compatibility metrics, they are only fetched when enabled.
+		if agent.CompatibleBuildTypes != nil {
+			ch <- prometheus.MustNewConstMetric(
+				collector.agentCompatible,
+				prometheus.GaugeValue,
+				float64(agent.CompatibleBuildTypes.Count),
+				labels...,
+			)
+		}
+		if agent.IncompatibleBuildTypes != nil {
+			ch <- prometheus.MustNewConstMetric(
+				collector.agentIncompatible,
+				prometheus.GaugeValue,
+				float64(agent.IncompatibleBuildTypes.Count),
+				labels...,
+			)
+		}
+
+		// Set the agent unexpectedly disconnected metric.
+		if agent.UnexpectedlyDisconnected() {
+			unexpectedlyDisconnected++
+		}
+		ch <- prometheus.MustNewConstMetric(
+			collector.agentUnexpectedlyDisconnected,
+			prometheus.GaugeValue,
+			float64(map[bool]int{true: 1, false: 0}[agent.UnexpectedlyDisconnected()]),
 			labels...,
 		)
 	}
+
+	// Set the rollup of unexpectedly disconnected agents.
+	ch <- prometheus.MustNewConstMetric(
+		collector.agentsUnexpectedlyDisconnected,
+		prometheus.GaugeValue,
+		float64(unexpectedlyDisconnected),
+	)
+
+	return nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAgentsCollector(t *testing.T) {
+	setConfig(t, map[string]interface{}{"agents.idle_build_id": false})
+	server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/agents": fixture(t, "agents.json")}))
+
+	expected := `
+# HELP teamcity_agent_authorized The authorized status of a TeamCity agent.
+# TYPE teamcity_agent_authorized gauge
+teamcity_agent_authorized{agent_id="1",agent_name="linux-1"} 1
+teamcity_agent_authorized{agent_id="2",agent_name="linux-2"} 1
+teamcity_agent_authorized{agent_id="3",agent_name="windows-1"} 0
+# HELP teamcity_agent_busy Whether a TeamCity agent is currently running a build.
+# TYPE teamcity_agent_busy gauge
+teamcity_agent_busy{agent_id="1",agent_name="linux-1"} 1
+teamcity_agent_busy{agent_id="2",agent_name="linux-2"} 0
+teamcity_agent_busy{agent_id="3",agent_name="windows-1"} 0
+# HELP teamcity_agent_connected The connected status of a TeamCity agent.
+# TYPE teamcity_agent_connected gauge
+teamcity_agent_connected{agent_id="1",agent_name="linux-1"} 1
+teamcity_agent_connected{agent_id="2",agent_name="linux-2"} 0
+teamcity_agent_connected{agent_id="3",agent_name="windows-1"} 1
+# HELP teamcity_agent_current_build_id The build ID of the current build of a TeamCity agent.
+# TYPE teamcity_agent_current_build_id gauge
+teamcity_agent_current_build_id{agent_id="1",agent_name="linux-1"} 42
+# HELP teamcity_agent_enabled The enabled status of a TeamCity agent.
+# TYPE teamcity_agent_enabled gauge
+teamcity_agent_enabled{agent_id="1",agent_name="linux-1"} 1
+teamcity_agent_enabled{agent_id="2",agent_name="linux-2"} 1
+teamcity_agent_enabled{agent_id="3",agent_name="windows-1"} 0
+# HELP teamcity_agent_unexpectedly_disconnected Whether a TeamCity agent is enabled but not connected.
+# TYPE teamcity_agent_unexpectedly_disconnected gauge
+teamcity_agent_unexpectedly_disconnected{agent_id="1",agent_name="linux-1"} 0
+teamcity_agent_unexpectedly_disconnected{agent_id="2",agent_name="linux-2"} 1
+teamcity_agent_unexpectedly_disconnected{agent_id="3",agent_name="windows-1"} 0
+# HELP teamcity_agents_unexpectedly_disconnected The number of TeamCity agents that are enabled but not connected.
+# TYPE teamcity_agents_unexpectedly_disconnected gauge
+teamcity_agents_unexpectedly_disconnected 1
+`
+	err := testutil.CollectAndCompare(NewTeamCityAgentCollector(server), strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAgentsCollectorIdleBuildID(t *testing.T) {
+	tests := []struct {
+		idle     bool
+		expected string
+	}{
+		{false, `
+teamcity_agent_current_build_id{agent_id="1",agent_name="linux-1"} 42
+`},
+		{true, `
+teamcity_agent_current_build_id{agent_id="1",agent_name="linux-1"} 42
+teamcity_agent_current_build_id{agent_id="2",agent_name="linux-2"} 0
+teamcity_agent_current_build_id{agent_id="3",agent_name="windows-1"} 0
+`},
+	}
+
+	for _, test := range tests {
+		setConfig(t, map[string]interface{}{"agents.idle_build_id": test.idle})
+		server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/agents": fixture(t, "agents.json")}))
+
+		expected := `
+# HELP teamcity_agent_current_build_id The build ID of the current build of a TeamCity agent.
+# TYPE teamcity_agent_current_build_id gauge` + test.expected
+		err := testutil.CollectAndCompare(NewTeamCityAgentCollector(server), strings.NewReader(expected), "teamcity_agent_current_build_id")
+		if err != nil {
+			t.Errorf("agents.idle_build_id = %v: %s", test.idle, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// CommentAnnotator extracts labels from build comments, e.g. the environment and version out of
+// "deploy: prod v1.2.3", using the named capture groups of a regular expression.
+type CommentAnnotator struct {
+	re     *regexp.Regexp
+	labels []string
+}
+
+// NewCommentAnnotator compiles a build comment regular expression, it returns nil when the expression is empty.
+func NewCommentAnnotator(expr string) (*CommentAnnotator, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid build comment regex: %w", err)
+	}
+
+	annotator := &CommentAnnotator{re: re}
+	for _, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		for _, label := range buildLabels {
+			if name == label {
+				return nil, fmt.Errorf("build comment regex group %q shadows a build label", name)
+			}
+		}
+		annotator.labels = append(annotator.labels, name)
+	}
+	if len(annotator.labels) == 0 {
+		return nil, fmt.Errorf("build comment regex %q has no named capture groups", expr)
+	}
+
+	return annotator, nil
+}
+
+// Labels returns the label names extracted from build comments, in the order of their capture groups.
+func (annotator *CommentAnnotator) Labels() []string {
+	return annotator.labels
+}
+
+// Annotate returns the label values extracted from a build comment, and whether the comment matched at all.
+func (annotator *CommentAnnotator) Annotate(comment string) ([]string, bool) {
+	match := annotator.re.FindStringSubmatch(comment)
+	if match == nil {
+		return nil, false
+	}
+
+	values := []string{}
+	for i, name := range annotator.re.SubexpNames() {
+		if name != "" {
+			values = append(values, match[i])
+		}
+	}
+	return values, true
+}
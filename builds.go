@@ -1,118 +1,733 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
-	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
 	"github.com/prometheus/client_golang/prometheus"
 	logrus "github.com/sirupsen/logrus"
 	viper "github.com/spf13/viper"
 )
 
-type BuildStatus int
-type BuildState int
+// buildStateNames and buildStatusNames name the build states and statuses by their numeric value, they label the
+// one-hot series.
+var buildStateNames = []string{"unknown", "queued", "finished", "running", "deleted", "interrupted", "canceled"}
+var buildStatusNames = []string{"UNKNOWN", "SUCCESS", "FAILURE", "ERROR"}
 
-const (
-	BuildStateUnknown BuildState = iota
-	BuildQueued
-	BuildFinished
-	BuildRunning
-	BuildDeleted
-)
+// buildExemplar returns the exemplar labels linking an observation to a build, the web URL is left out when it does not
+// fit in the size Prometheus allows for exemplar labels.
+func buildExemplar(build Build) prometheus.Labels {
+	id := fmt.Sprintf("%d", build.ID)
+	exemplar := prometheus.Labels{"build_id": id}
 
-const (
-	BuildStatusUnknown BuildStatus = iota
-	BuildSuccess
-	BuildFailure
-)
+	runes := utf8.RuneCountInString("build_id" + id + "web_url" + build.WebURL)
+	if build.WebURL != "" && runes <= prometheus.ExemplarMaxRunes {
+		exemplar["web_url"] = build.WebURL
+	}
+	return exemplar
+}
+
+// TopFailureReasons returns the most frequent problem occurrence type among the failed builds that finished after
+// since, keyed by build type. Ties are broken alphabetically so the reported reason is stable between scrapes.
+func TopFailureReasons(builds []Build, since time.Time) map[string]string {
+	tallies := map[string]map[string]int{}
+	for _, build := range builds {
+		if tcapi.ParseBuildStatus(build.Status) != tcapi.BuildFailure || !build.FinishDate.After(since) {
+			continue
+		}
 
-func ParseBuildState(s string) BuildState {
-	switch s {
-	case "queued":
-		return BuildQueued
-	case "finished":
-		return BuildFinished
-	case "running":
-		return BuildRunning
-	case "deleted":
-		return BuildDeleted
+		if tallies[build.BuildTypeID] == nil {
+			tallies[build.BuildTypeID] = map[string]int{}
+		}
+		for _, problem := range build.ProblemOccurrences.ProblemOccurrences {
+			tallies[build.BuildTypeID][problem.Type]++
+		}
 	}
-	return BuildStateUnknown
+
+	reasons := map[string]string{}
+	for buildType, tally := range tallies {
+		for reason, count := range tally {
+			top, ok := reasons[buildType]
+			if !ok || count > tally[top] || (count == tally[top] && reason < top) {
+				reasons[buildType] = reason
+			}
+		}
+	}
+	return reasons
 }
 
-func ParseBuildStatus(s string) BuildStatus {
-	switch s {
-	case "SUCCESS":
-		return BuildSuccess
-	case "FAILURE":
-		return BuildFailure
+// AverageQueueWaits returns the average queue wait of the finished builds that finished after since, keyed by build
+// type. Builds missing their queued or start date are left out.
+func AverageQueueWaits(builds []Build, since time.Time) map[string]time.Duration {
+	totals := map[string]time.Duration{}
+	counts := map[string]int{}
+	for _, build := range builds {
+		if tcapi.ParseBuildState(build.State) != tcapi.BuildFinished || !build.FinishDate.After(since) {
+			continue
+		}
+		if build.QueuedDate.IsZero() || build.StartDate.IsZero() {
+			continue
+		}
+		totals[build.BuildTypeID] += build.QueueWait()
+		counts[build.BuildTypeID]++
+	}
+
+	averages := map[string]time.Duration{}
+	for buildType, total := range totals {
+		averages[buildType] = total / time.Duration(counts[buildType])
 	}
-	return BuildStatusUnknown
+	return averages
 }
 
-type Build struct {
-	ID          uint64       `json:"id"`
-	BuildTypeID string       `json:"buildTypeId"`
-	Status      string       `json:"status"`
-	State       string       `json:"state"`
-	StartDate   TeamCityTime `json:"startDate,omitempty"`
-	FinishDate  TeamCityTime `json:"finishDate,omitempty"`
+// LatestFinishDates returns the finish time of the most recently finished build of each build type.
+func LatestFinishDates(builds []Build) map[string]time.Time {
+	latest := map[string]time.Time{}
+	for _, build := range builds {
+		if build.FinishDate.IsZero() {
+			continue
+		}
+		if build.FinishDate.After(latest[build.BuildTypeID]) {
+			latest[build.BuildTypeID] = build.FinishDate.Time
+		}
+	}
+	return latest
 }
 
-type BuildResponse struct {
-	Count    uint64  `json:"count"`
-	HRef     string  `json:"href,omitempty"`
-	NextHRef string  `json:"nextHref,omitempty"`
-	PrevHRef string  `json:"prevHref,omitempty"`
-	Builds   []Build `json:"build"`
+// LastSuccessDates returns the finish time of the most recent successful build of each build type.
+func LastSuccessDates(builds []Build) map[string]time.Time {
+	latest := map[string]time.Time{}
+	for _, build := range builds {
+		if tcapi.ParseBuildState(build.State) != tcapi.BuildFinished || tcapi.ParseBuildStatus(build.Status) != tcapi.BuildSuccess {
+			continue
+		}
+		if build.FinishDate.After(latest[build.BuildTypeID]) {
+			latest[build.BuildTypeID] = build.FinishDate.Time
+		}
+	}
+	return latest
+}
+
+// ConsecutiveFailures returns the number of finished builds of each build type that failed since its last successful
+// build. Canceled builds neither break nor extend a streak.
+func ConsecutiveFailures(builds []Build) map[string]int {
+	// Build IDs increase over time, walking them from the highest visits the latest builds first.
+	sorted := append([]Build{}, builds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID > sorted[j].ID })
+
+	failures := map[string]int{}
+	succeeded := map[string]bool{}
+	for _, build := range sorted {
+		if tcapi.ParseBuildState(build.State) != tcapi.BuildFinished || build.Canceled() {
+			continue
+		}
+		if _, ok := failures[build.BuildTypeID]; !ok {
+			failures[build.BuildTypeID] = 0
+		}
+		if succeeded[build.BuildTypeID] {
+			continue
+		}
+
+		switch tcapi.ParseBuildStatus(build.Status) {
+		case tcapi.BuildSuccess:
+			succeeded[build.BuildTypeID] = true
+		case tcapi.BuildFailure, tcapi.BuildError:
+			failures[build.BuildTypeID]++
+		}
+	}
+	return failures
+}
+
+// LatestBuildsPerType returns the IDs of the most recent builds of each build type, at most count per build type. It
+// returns nil when count is not positive, meaning every build is kept.
+func LatestBuildsPerType(builds []Build, count int) map[uint64]bool {
+	if count <= 0 {
+		return nil
+	}
+
+	// Build IDs increase over time, so the most recent builds have the highest IDs.
+	sorted := append([]Build{}, builds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID > sorted[j].ID })
+
+	kept := map[string]int{}
+	latest := map[uint64]bool{}
+	for _, build := range sorted {
+		if kept[build.BuildTypeID] < count {
+			kept[build.BuildTypeID]++
+			latest[build.ID] = true
+		}
+	}
+	return latest
+}
+
+// LastBuilds returns the most recent finished build of each build type. Build types without a finished build fall
+// back to their most recent build in any state, so that they are still reported.
+func LastBuilds(builds []Build) map[string]Build {
+	last := map[string]Build{}
+	for _, build := range builds {
+		current, ok := last[build.BuildTypeID]
+		if !ok {
+			last[build.BuildTypeID] = build
+			continue
+		}
+
+		finished := tcapi.ParseBuildState(build.State) == tcapi.BuildFinished
+		currentFinished := tcapi.ParseBuildState(current.State) == tcapi.BuildFinished
+		if (finished && !currentFinished) || (finished == currentFinished && build.ID > current.ID) {
+			last[build.BuildTypeID] = build
+		}
+	}
+	return last
+}
+
+// LatestFailedBuilds returns the most recent failed build of each build type.
+func LatestFailedBuilds(builds []Build) map[string]Build {
+	latest := map[string]Build{}
+	for _, build := range builds {
+		if tcapi.ParseBuildStatus(build.Status) != tcapi.BuildFailure {
+			continue
+		}
+		if current, ok := latest[build.BuildTypeID]; !ok || build.ID > current.ID {
+			latest[build.BuildTypeID] = build
+		}
+	}
+	return latest
+}
+
+// LatestChainBuilds returns the most recent finished build with snapshot dependencies of each build type.
+func LatestChainBuilds(builds []Build) map[string]Build {
+	latest := map[string]Build{}
+	for _, build := range builds {
+		if tcapi.ParseBuildState(build.State) != tcapi.BuildFinished || build.Dependencies == nil || build.Dependencies.Count == 0 {
+			continue
+		}
+		if current, ok := latest[build.BuildTypeID]; !ok || build.ID > current.ID {
+			latest[build.BuildTypeID] = build
+		}
+	}
+	return latest
+}
+
+// ChainDuration returns the time from the first build of a snapshot dependency chain starting to the top build
+// finishing. Dependencies that started before the top build was queued were reused from an earlier chain and do not
+// count towards its duration. It is zero when the top build has not finished.
+func ChainDuration(top Build, chain []Build) time.Duration {
+	if top.StartDate.IsZero() || top.FinishDate.IsZero() {
+		return 0
+	}
+
+	start := top.StartDate.Time
+	for _, build := range chain {
+		if build.StartDate.IsZero() || build.StartDate.Before(top.QueuedDate.Time) {
+			continue
+		}
+		if build.StartDate.Before(start) {
+			start = build.StartDate.Time
+		}
+	}
+	return top.FinishDate.Sub(start)
+}
+
+// MessageStatusError is the status of build log messages reported as errors.
+const MessageStatusError = 4
+
+type Message struct {
+	ID     uint64 `json:"id"`
+	Text   string `json:"text"`
+	Status int    `json:"status"`
+}
+
+type MessagesResponse struct {
+	Messages []Message `json:"messages"`
+}
+
+// ErrorLines returns the number of error messages in the build log listing.
+func (messages MessagesResponse) ErrorLines() int {
+	count := 0
+	for _, message := range messages.Messages {
+		if message.Status == MessageStatusError {
+			count++
+		}
+	}
+	return count
+}
+
+// buildsScrape holds the state shared by every project visited during a single collection.
+type buildsScrape struct {
+	// Only builds that finished after this time are accounted for in the windowed rollups.
+	since time.Time
+
+	mutex        sync.Mutex
+	agentSeconds map[string]float64
+	users        map[string]bool
+}
+
+// addUser records a user that triggered a build, each user is only counted once per collection.
+func (scrape *buildsScrape) addUser(username string) {
+	scrape.mutex.Lock()
+	defer scrape.mutex.Unlock()
+	scrape.users[username] = true
+}
+
+// addAgentSeconds attributes the agent time of a build to a top-level project.
+func (scrape *buildsScrape) addAgentSeconds(project string, seconds float64) {
+	scrape.mutex.Lock()
+	defer scrape.mutex.Unlock()
+	scrape.agentSeconds[project] += seconds
 }
 
 type TeamCityBuildsCollector struct {
-	client *teamcity.Client
+	api    *tcapi.Client
+	server string
+	addr   string
+	root   string
+
+	// Bounds the number of projects collected concurrently across the whole project tree.
+	semaphore Semaphore
+	filter    *ProjectFilter
+	branches  *BranchFilter
+	annotator *CommentAnnotator
+	labels    []string
+	now       func() time.Time
 
-	buildStartTime  *prometheus.Desc
-	buildFinishTime *prometheus.Desc
-	buildState      *prometheus.Desc
-	buildStatus     *prometheus.Desc
+	// The builds of each project kept between incremental collections, nil unless collecting incrementally.
+	history *BuildHistory
+
+	// The finish times of the builds whose duration has already been observed by build ID, durations are only observed
+	// once per build. Builds are forgotten once they finished before the builds window.
+	observedBuilds *sync.Map
+	buildDurations *prometheus.HistogramVec
+
+	buildStartTime    *prometheus.Desc
+	buildFinishTime   *prometheus.Desc
+	buildDuration     *prometheus.Desc
+	buildQueueWait    *prometheus.Desc
+	buildState        *prometheus.Desc
+	buildStatus       *prometheus.Desc
+	buildTimeout      *prometheus.Desc
+	buildComposite    *prometheus.Desc
+	buildPersonal     *prometheus.Desc
+	buildCanceled     *prometheus.Desc
+	buildErrorLines   *prometheus.Desc
+	buildChain        *prometheus.Desc
+	buildAnnotation   *prometheus.Desc
+	buildTests        *prometheus.Desc
+	buildTestsFailed  *prometheus.Desc
+	buildTestsIgnored *prometheus.Desc
+	buildProblems     *prometheus.Desc
+	buildArtifacts    *prometheus.Desc
+	buildArtifactSize *prometheus.Desc
+
+	activeBuildUsers          *prometheus.Desc
+	buildTypeAvgQueueSeconds  *prometheus.Desc
+	buildTypeLastBuildStatus  *prometheus.Desc
+	buildTypeStale            *prometheus.Desc
+	buildTypeLastSuccess      *prometheus.Desc
+	buildTypeFailures         *prometheus.Desc
+	buildTypeTopFailureReason *prometheus.Desc
+	projectAgentSeconds       *prometheus.Desc
+}
+
+// buildLabels are the labels the per-build metrics can carry, annotation labels must not shadow them. Builds of build
+// types without VCS branches have an empty branch, and builds that have not started yet have no agent.
+var buildLabels = []string{
+	"project_id",
+	"build_type_id",
+	"build_id",
+	"build_number",
+	"branch",
+	"agent_name",
+	"triggered_by",
+	"trigger_type",
+	"project_name",
+	"build_type_name",
+}
+
+// defaultBuildLabels are the labels of the per-build metrics unless configured otherwise.
+const defaultBuildLabels = "project_id,build_type_id,build_id,branch,project_name"
+
+// requiredBuildLabels are the build labels every label set needs, without them the builds of different projects or
+// build types would share series.
+var requiredBuildLabels = []string{"project_id", "build_type_id"}
+
+// ParseBuildLabels parses the comma-separated list of per-build metric labels, it falls back to the default labels when
+// the list is empty.
+func ParseBuildLabels(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		value = defaultBuildLabels
+	}
+
+	labels := []string{}
+	seen := map[string]bool{}
+	for _, label := range strings.Split(value, ",") {
+		label = strings.TrimSpace(label)
+		if label == "" || seen[label] {
+			continue
+		}
+
+		known := false
+		for _, name := range buildLabels {
+			known = known || name == label
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown build label %q", label)
+		}
+
+		seen[label] = true
+		labels = append(labels, label)
+	}
+
+	for _, label := range requiredBuildLabels {
+		if !seen[label] {
+			return nil, fmt.Errorf("build labels lack the required %q label", label)
+		}
+	}
+
+	return labels, nil
 }
 
-func NewTeamCityBuildsCollector(client *teamcity.Client) *TeamCityBuildsCollector {
+// BuildLabels returns the configured labels of the per-build metrics. The build type name label is still added when
+// enabled on its own, renaming a build type starts new series.
+func BuildLabels() []string {
+	// The exporter refuses to start with unknown build labels, there is no error left to handle here.
+	labels, _ := ParseBuildLabels(viper.GetString("builds.labels"))
+	if viper.GetBool("builds.build_type_name_label") && !hasLabel(labels, "build_type_name") {
+		labels = append(labels, "build_type_name")
+	}
+	return labels
+}
+
+// hasLabel reports whether a label is among the given labels.
+func hasLabel(labels []string, label string) bool {
+	for _, name := range labels {
+		if name == label {
+			return true
+		}
+	}
+	return false
+}
+
+// buildLabelValues returns the values of the given per-build labels for a build of a project. Builds triggered by a
+// user are attributed to the username, others to their trigger type, e.g. vcs or schedule.
+func buildLabelValues(build Build, labels []string, project string, projectName string) []string {
+	values := make([]string, 0, len(labels))
+	for _, label := range labels {
+		switch label {
+		case "project_id":
+			values = append(values, project)
+		case "build_type_id":
+			values = append(values, build.BuildTypeID)
+		case "build_id":
+			values = append(values, fmt.Sprintf("%d", build.ID))
+		case "build_number":
+			values = append(values, build.Number)
+		case "branch":
+			values = append(values, build.BranchName)
+		case "agent_name":
+			agent := ""
+			if build.Agent != nil {
+				agent = build.Agent.Name
+			}
+			values = append(values, agent)
+		case "triggered_by":
+			trigger := build.Triggered.Type
+			if build.Triggered.User != nil && build.Triggered.User.Username != "" {
+				trigger = build.Triggered.User.Username
+			}
+			values = append(values, trigger)
+		case "trigger_type":
+			values = append(values, build.TriggerType())
+		case "project_name":
+			values = append(values, projectName)
+		case "build_type_name":
+			values = append(values, build.BuildType.Name)
+		}
+	}
+	return values
+}
+
+// ParseDurationBuckets parses the comma-separated upper bounds, in seconds, of the build duration histogram buckets. It
+// falls back to exponential buckets from 30 seconds to about four hours when the list is empty.
+func ParseDurationBuckets(value string) ([]float64, error) {
+	buckets := []float64{}
+	for _, bound := range strings.Split(value, ",") {
+		bound = strings.TrimSpace(bound)
+		if bound == "" {
+			continue
+		}
+
+		seconds, err := strconv.ParseFloat(bound, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build duration bucket %q: %w", bound, err)
+		}
+		if len(buckets) > 0 && seconds <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("build duration buckets must be increasing, %q is not", bound)
+		}
+		buckets = append(buckets, seconds)
+	}
+
+	if len(buckets) == 0 {
+		return prometheus.ExponentialBuckets(30, 2, 10), nil
+	}
+	return buckets, nil
+}
+
+func NewTeamCityBuildsCollector(server *Server) *TeamCityBuildsCollector {
 	constLabels := prometheus.Labels{}
 
+	// The exporter refuses to start with invalid duration buckets, there is no error left to handle here.
+	buckets, _ := ParseDurationBuckets(viper.GetString("builds.duration_buckets"))
+
+	// Native histograms are only exposed to scrapes that negotiate them, others get the classic buckets.
+	nativeHistogramBucketFactor := 0.0
+	if viper.GetBool("metrics.native_histograms") {
+		nativeHistogramBucketFactor = 1.1
+	}
+
+	// The exporter refuses to start with an invalid branch filter, there is no error left to handle here.
+	branches, _ := ConfiguredBranchFilter()
+
+	labels := BuildLabels()
+
+	// With one-hot series the state and status are labels, one series per possible value, instead of the gauge value.
+	stateLabels, statusLabels := labels, labels
+	if viper.GetBool("builds.one_hot") {
+		stateLabels = append(append([]string{}, labels...), "state")
+		statusLabels = append(append([]string{}, labels...), "status")
+	}
+
+	// The annotation metric only exists when a build comment regex is configured, its labels come from the regex.
+	annotator, _ := NewCommentAnnotator(viper.GetString("builds.comment_regex"))
+	var buildAnnotation *prometheus.Desc
+	if annotator != nil {
+		buildAnnotation = prometheus.NewDesc(
+			"teamcity_build_annotation",
+			"The annotation extracted from the comment of a TeamCity build.",
+			append(append([]string{}, labels...), annotator.Labels()...),
+			constLabels,
+		)
+	}
+
+	var history *BuildHistory
+	if viper.GetBool("builds.incremental") {
+		history = NewBuildHistory()
+	}
+
 	return &TeamCityBuildsCollector{
-		// Set the TeamCity client.
-		client: client,
+		// Set the TeamCity client and address, the project to collect, and the clock used for windowed rollups.
+		api:       server.API,
+		server:    server.Name,
+		addr:      server.Addr,
+		root:      viper.GetString("root.project.id"),
+		now:       time.Now,
+		semaphore: server.Semaphore,
+		filter:    server.Filter,
+		branches:  branches,
+		annotator: annotator,
+		labels:    labels,
+		history:   history,
+
+		// Build duration histogram.
+		observedBuilds: &sync.Map{},
+		buildDurations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:                        "teamcity_build_type_duration_seconds",
+				Help:                        "The duration of finished TeamCity build jobs.",
+				ConstLabels:                 constLabels,
+				Buckets:                     buckets,
+				NativeHistogramBucketFactor: nativeHistogramBucketFactor,
+			},
+			[]string{"build_type_id"},
+		),
 
 		// Build metric descriptions.
+		buildAnnotation: buildAnnotation,
 		buildStartTime: prometheus.NewDesc(
 			"teamcity_build_start_time",
 			"The start time of a TeamCity build job.",
-			[]string{"project_id", "build_type_id", "build_id"},
+			labels,
 			constLabels,
 		),
 
 		buildFinishTime: prometheus.NewDesc(
 			"teamcity_build_finish_time",
 			"The finish time of a TeamCity build job.",
-			[]string{"project_id", "build_type_id", "build_id"},
+			labels,
+			constLabels,
+		),
+
+		buildDuration: prometheus.NewDesc(
+			"teamcity_build_duration_seconds",
+			"The duration of a finished TeamCity build job, or the elapsed time of a running one.",
+			labels,
+			constLabels,
+		),
+
+		buildQueueWait: prometheus.NewDesc(
+			"teamcity_build_queue_wait_seconds",
+			"The time a TeamCity build job waited in the queue before starting.",
+			labels,
 			constLabels,
 		),
 
 		buildState: prometheus.NewDesc(
 			"teamcity_build_state",
 			"The state of a TeamCity build job.",
-			[]string{"project_id", "build_type_id", "build_id"},
+			stateLabels,
 			constLabels,
 		),
 
 		buildStatus: prometheus.NewDesc(
 			"teamcity_build_status",
 			"The status of a TeamCity build job.",
-			[]string{"project_id", "build_type_id", "build_id"},
+			statusLabels,
+			constLabels,
+		),
+
+		buildTests: prometheus.NewDesc(
+			"teamcity_build_tests_total",
+			"The total number of tests run by a finished TeamCity build job.",
+			labels,
+			constLabels,
+		),
+
+		buildTestsFailed: prometheus.NewDesc(
+			"teamcity_build_tests_failed",
+			"The number of failed tests of a finished TeamCity build job.",
+			labels,
+			constLabels,
+		),
+
+		buildTestsIgnored: prometheus.NewDesc(
+			"teamcity_build_tests_ignored",
+			"The number of ignored tests of a finished TeamCity build job.",
+			labels,
+			constLabels,
+		),
+
+		buildProblems: prometheus.NewDesc(
+			"teamcity_build_problems_total",
+			"The total number of problems of a finished TeamCity build job.",
+			labels,
+			constLabels,
+		),
+
+		buildArtifacts: prometheus.NewDesc(
+			"teamcity_build_artifacts_count",
+			"The number of top-level artifacts published by a finished TeamCity build job.",
+			labels,
+			constLabels,
+		),
+
+		buildArtifactSize: prometheus.NewDesc(
+			"teamcity_build_artifacts_size_bytes",
+			"The total size of the artifacts published by a finished TeamCity build job.",
+			labels,
+			constLabels,
+		),
+
+		buildComposite: prometheus.NewDesc(
+			"teamcity_build_composite",
+			"Whether a TeamCity build job is a composite build.",
+			labels,
+			constLabels,
+		),
+
+		buildPersonal: prometheus.NewDesc(
+			"teamcity_build_personal",
+			"Whether a TeamCity build job is a personal build.",
+			labels,
+			constLabels,
+		),
+
+		buildCanceled: prometheus.NewDesc(
+			"teamcity_build_canceled",
+			"Whether a TeamCity build job was canceled.",
+			labels,
+			constLabels,
+		),
+
+		buildTimeout: prometheus.NewDesc(
+			"teamcity_build_timeout",
+			"Whether a failed TeamCity build job exceeded its execution timeout.",
+			[]string{"build_type_id", "build_id"},
+			constLabels,
+		),
+
+		buildErrorLines: prometheus.NewDesc(
+			"teamcity_build_error_lines",
+			"The number of error lines in the build log of the latest failed build of a TeamCity build type.",
+			[]string{"build_type_id", "build_id"},
+			constLabels,
+		),
+
+		buildChain: prometheus.NewDesc(
+			"teamcity_build_chain_duration_seconds",
+			"The duration of the snapshot dependency chain of the latest finished chain build of a TeamCity build type.",
+			[]string{"build_type_id", "build_id"},
+			constLabels,
+		),
+
+		activeBuildUsers: prometheus.NewDesc(
+			"teamcity_active_build_users",
+			"The number of distinct users that triggered TeamCity builds within the builds window.",
+			[]string{},
+			constLabels,
+		),
+
+		buildTypeAvgQueueSeconds: prometheus.NewDesc(
+			"teamcity_build_type_avg_queue_seconds",
+			"The average time the builds of a TeamCity build type finished within the builds window waited in the queue.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+
+		buildTypeLastBuildStatus: prometheus.NewDesc(
+			"teamcity_build_type_last_build_status",
+			"The status of the last finished build of a TeamCity build type, or of its latest build when none finished.",
+			[]string{"build_type_id", "state"},
+			constLabels,
+		),
+
+		buildTypeStale: prometheus.NewDesc(
+			"teamcity_build_type_stale",
+			"Whether the latest build of a TeamCity build type finished longer ago than the staleness threshold.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+
+		buildTypeLastSuccess: prometheus.NewDesc(
+			"teamcity_build_type_last_successful_build_timestamp",
+			"The finish time of the latest successful build of a TeamCity build type.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+
+		buildTypeFailures: prometheus.NewDesc(
+			"teamcity_build_type_consecutive_failures",
+			"The number of finished builds of a TeamCity build type that failed since its latest successful build.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+
+		buildTypeTopFailureReason: prometheus.NewDesc(
+			"teamcity_build_type_top_failure_reason",
+			"The most frequent problem type of the failed builds of a TeamCity build type within the builds window.",
+			[]string{"build_type_id", "reason"},
+			constLabels,
+		),
+
+		projectAgentSeconds: prometheus.NewDesc(
+			"teamcity_project_agent_seconds",
+			"The agent time consumed by the builds of a top-level TeamCity project within the builds window.",
+			[]string{"project_id"},
 			constLabels,
 		),
 	}
@@ -121,44 +736,143 @@ func NewTeamCityBuildsCollector(client *teamcity.Client) *TeamCityBuildsCollecto
 func (collector TeamCityBuildsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.buildFinishTime
 	ch <- collector.buildStartTime
+	ch <- collector.buildDuration
+	ch <- collector.buildQueueWait
 	ch <- collector.buildState
 	ch <- collector.buildStatus
+	ch <- collector.buildTimeout
+	ch <- collector.buildComposite
+	ch <- collector.buildPersonal
+	ch <- collector.buildCanceled
+	ch <- collector.buildErrorLines
+	ch <- collector.buildChain
+	ch <- collector.buildTests
+	ch <- collector.buildTestsFailed
+	ch <- collector.buildTestsIgnored
+	ch <- collector.buildProblems
+	ch <- collector.buildArtifacts
+	ch <- collector.buildArtifactSize
+	if collector.buildAnnotation != nil {
+		ch <- collector.buildAnnotation
+	}
+	ch <- collector.activeBuildUsers
+	ch <- collector.buildTypeAvgQueueSeconds
+	ch <- collector.buildTypeLastBuildStatus
+	ch <- collector.buildTypeStale
+	ch <- collector.buildTypeLastSuccess
+	ch <- collector.buildTypeFailures
+	ch <- collector.buildTypeTopFailureReason
+	ch <- collector.projectAgentSeconds
+	collector.buildDurations.Describe(ch)
 }
 
 func (collector TeamCityBuildsCollector) Collect(ch chan<- prometheus.Metric) {
 	logrus.Info("collecting TeamCity builds metrics")
 
-	err := collector.collectBuildMetrics(viper.GetString("root.project.id"), ch)
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	scrape := &buildsScrape{
+		since:        collector.now().Add(-viper.GetDuration("builds.since")),
+		agentSeconds: map[string]float64{},
+		users:        map[string]bool{},
+	}
+	err := collector.collectBuildMetrics(ctx, collector.root, "", collector.filter.Root(), scrape, ch)
 	if err != nil {
 		logrus.Error(err)
+		countScrapeError(collector.server, "builds")
 	}
+	collector.forgetObservedBuilds(scrape.since)
+
+	// Set the agent time metric for each top-level project.
+	for project, seconds := range scrape.agentSeconds {
+		ch <- prometheus.MustNewConstMetric(
+			collector.projectAgentSeconds,
+			prometheus.GaugeValue,
+			seconds,
+			project,
+		)
+	}
+
+	// Set the number of distinct users that triggered builds.
+	ch <- prometheus.MustNewConstMetric(
+		collector.activeBuildUsers,
+		prometheus.GaugeValue,
+		float64(len(scrape.users)),
+	)
+
+	collector.buildDurations.Collect(ch)
 }
 
-func (collector *TeamCityBuildsCollector) collectBuildMetrics(identifier string, ch chan<- prometheus.Metric) error {
+// forgetObservedBuilds drops the builds that finished before since from the observed builds, their durations are no
+// longer observed so there is no need to remember them.
+func (collector *TeamCityBuildsCollector) forgetObservedBuilds(since time.Time) {
+	collector.observedBuilds.Range(func(id, finished interface{}) bool {
+		if finished.(time.Time).Before(since) {
+			collector.observedBuilds.Delete(id)
+		}
+		return true
+	})
+}
+
+// collectBuildMetrics collects the build metrics of a project and its subprojects. The top-level project is the
+// direct child of the root project the project belongs to, it is empty for the root project itself. The parent flag
+// tells whether the parent project is included by the project filter.
+func (collector *TeamCityBuildsCollector) collectBuildMetrics(ctx context.Context, identifier string, topLevel string, parent bool, scrape *buildsScrape, ch chan<- prometheus.Metric) error {
 	logger := logrus.WithFields(logrus.Fields{"project": identifier})
 
+	if !collector.filter.Walk(identifier, parent) {
+		logger.Debug("skipping excluded project")
+		return nil
+	}
+	included := collector.filter.Included(identifier, parent)
+
+	// Only hold a concurrency slot for the project's own requests, holding it while waiting on the subprojects would
+	// deadlock once the project tree is deeper than the concurrency limit.
+	release := collector.semaphore.Acquire()
+	defer release()
+
 	logger.Info("collecting project")
-	p, err := collector.client.Projects.GetByID(identifier)
+	p, err := collector.api.GetProject(ctx, identifier)
 	if err != nil {
 		return err
 	}
 
-	// Collect metrics on builds for the project.
-	err = collector.collectProjectBuildMetrics(p.ID, ch)
-	if err != nil {
-		return err
+	// Collect metrics on builds for the project, the root project's own builds are attributed to itself.
+	owner := topLevel
+	if owner == "" {
+		owner = p.ID
+	}
+	if included {
+		scrape.addAgentSeconds(owner, 0)
+
+		err = collector.collectProjectBuildMetrics(ctx, p.ID, p.Name, owner, scrape, ch)
+		if err != nil {
+			return err
+		}
 	}
 
+	release()
+
 	// Collect metrics on the subprojects.
 	wg := sync.WaitGroup{}
-	wg.Add(p.ChildProjects.Count)
 	for _, subproject := range p.ChildProjects.Items {
+		wg.Add(1)
 		go func(identifier string) {
-			err := collector.collectBuildMetrics(identifier, ch)
+			defer wg.Done()
+			defer recoverCollectorPanic(collector.server, "builds")
+
+			// The direct children of the root project are the top-level projects.
+			owner := topLevel
+			if owner == "" {
+				owner = identifier
+			}
+
+			err := collector.collectBuildMetrics(ctx, identifier, owner, included, scrape, ch)
 			if err != nil {
 				logger.Error(err)
+				countScrapeError(collector.server, "builds")
 			}
-			wg.Done()
 		}(subproject.ID)
 	}
 
@@ -169,84 +883,420 @@ func (collector *TeamCityBuildsCollector) collectBuildMetrics(identifier string,
 	return nil
 }
 
-func (collector *TeamCityBuildsCollector) collectProjectBuildMetrics(identifier string, ch chan<- prometheus.Metric) error {
+func (collector *TeamCityBuildsCollector) collectProjectBuildMetrics(ctx context.Context, identifier string, name string, owner string, scrape *buildsScrape, ch chan<- prometheus.Metric) error {
 	logger := logrus.WithFields(logrus.Fields{"project": identifier})
 
-	url := fmt.Sprintf(
-		"%s/app/rest/builds?locator=count:%d,project:id:%s&fields=count,nextHref,build(id,buildTypeId,status,state,startDate,finishDate)",
-		viper.GetString("addr"),
-		viper.GetUint("page.count"),
-		identifier,
-	)
-
-	client := collector.client.HTTPClient
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+	// TeamCity only lists default branch builds unless told otherwise.
+	locator := fmt.Sprintf("count:%d,project:id:%s,running:any,branch:(%s)", viper.GetUint("page.count"), identifier, collector.branches.Locator())
+	// Collecting incrementally, only the builds that started since the previous collection's are fetched, unless the
+	// lookback reaches less far back.
+	var since, cutoff time.Time
+	if lookback := viper.GetDuration("builds.lookback"); lookback > 0 {
+		since = collector.now().Add(-lookback)
+		cutoff = since
 	}
-	request.Header.Set("Accept", "application/json")
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", viper.GetString("token")))
-	response, err := client.Do(request)
-	if err != nil {
-		return err
+	if collector.history != nil {
+		if previous, ok := collector.history.Since(identifier); ok && previous.After(since) {
+			since = previous
+		}
+	}
+	if !since.IsZero() {
+		locator = fmt.Sprintf("%s,sinceDate:%s", locator, since.UTC().Format(tcapi.TimeLayout))
+	}
+	if extra := strings.TrimSpace(viper.GetString("builds.locator_extra")); extra != "" {
+		locator = fmt.Sprintf("%s,%s", locator, extra)
 	}
 
-	if response.StatusCode == http.StatusNotFound {
-		return nil
+	// The comments, build numbers, agents, build type names, artifacts and web URLs are only needed when their labels
+	// are enabled.
+	fields := "id,buildTypeId,branchName,defaultBranch,status,state,queuedDate,startDate,finishDate,problemOccurrences(count,problemOccurrence(type)),testOccurrences(count,passed,failed,ignored),triggered(type,user(username)),composite,personal,canceledInfo(timestamp)"
+	if collector.annotator != nil {
+		fields = fmt.Sprintf("%s,comment(text)", fields)
+	}
+	if hasLabel(collector.labels, "build_number") {
+		fields = fmt.Sprintf("%s,number", fields)
+	}
+	if hasLabel(collector.labels, "agent_name") {
+		fields = fmt.Sprintf("%s,agent(name)", fields)
+	}
+	if hasLabel(collector.labels, "build_type_name") {
+		fields = fmt.Sprintf("%s,buildType(id,name)", fields)
+	}
+	if viper.GetBool("builds.collect_artifacts") {
+		fields = fmt.Sprintf("%s,artifacts(count),statistics(property(name,value))", fields)
+	}
+	if viper.GetBool("builds.collect_chain_durations") {
+		fields = fmt.Sprintf("%s,snapshot-dependencies(count)", fields)
+	}
+	if viper.GetBool("metrics.openmetrics") {
+		fields = fmt.Sprintf("%s,webUrl", fields)
 	}
 
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
+	// Follow the next page links until all builds are gathered, metrics are only emitted once every page is in. The
+	// page cap keeps a runaway project from holding the whole build history in memory.
+	maxPages := viper.GetInt("builds.max_pages")
+	listed, err := collector.api.ListBuilds(ctx, locator, fields, maxPages)
+	if errors.Is(err, tcapi.ErrPageLimit) {
+		logger.WithFields(logrus.Fields{"pages": maxPages}).Warn("reached the builds page cap, ignoring the remaining builds")
+	} else if err != nil {
 		return err
 	}
 
+	// Builds of branches the branch pattern does not match are dropped before any metric sees them.
 	builds := BuildResponse{}
-	err = json.Unmarshal(body, &builds)
-	if err != nil {
-		return err
+	for _, build := range listed {
+		if collector.branches.Match(build.BranchName) {
+			builds.Builds = append(builds.Builds, build)
+		}
 	}
 
-	logger.WithFields(logrus.Fields{"count": builds.Count}).Info("found builds")
+	if collector.history != nil {
+		fetched := len(builds.Builds)
+		builds.Builds = collector.history.Merge(identifier, builds.Builds, cutoff, viper.GetInt("builds.history_limit"))
+		logger.WithFields(logrus.Fields{"fetched": fetched}).Debug("merged builds into the build history")
+	}
+
+	logger.WithFields(logrus.Fields{"count": len(builds.Builds)}).Info("found builds")
+	latest := LatestBuildsPerType(builds.Builds, viper.GetInt("builds.per_type"))
+	retention := viper.GetDuration("builds.retention")
+	timestamps := viper.GetBool("builds.timestamps")
+	seen := map[string]bool{}
 	for _, build := range builds.Builds {
-		labels := []string{identifier, build.BuildTypeID, fmt.Sprintf("%d", build.ID)}
+		// Observe the duration of builds that finished within the window and we have not seen before, with the build as
+		// exemplar when the exemplars can be exposed. Older builds are left out, as they are no longer remembered.
+		if duration := build.Duration(); duration > 0 && build.FinishDate.After(scrape.since) {
+			if _, observed := collector.observedBuilds.LoadOrStore(build.ID, build.FinishDate.Time); !observed {
+				observer := collector.buildDurations.WithLabelValues(build.BuildTypeID)
+				if viper.GetBool("metrics.openmetrics") {
+					observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), buildExemplar(build))
+				} else {
+					observer.Observe(duration.Seconds())
+				}
+			}
+
+			// Account the agent time of builds that finished within the window to their top-level project.
+			scrape.addAgentSeconds(owner, duration.Seconds())
+		}
+
+		// Record the user that triggered builds that started within the window.
+		if build.Triggered.User != nil && build.Triggered.User.Username != "" && build.StartDate.After(scrape.since) {
+			scrape.addUser(build.Triggered.User.Username)
+		}
+
+		// Builds beyond the per build type cap only feed the rollups, they get no series of their own.
+		if latest != nil && !latest[build.ID] {
+			continue
+		}
+
+		// So do builds that finished longer ago than the retention period, their series go stale in Prometheus.
+		if retention > 0 && !build.FinishDate.IsZero() && build.FinishDate.Before(collector.now().Add(-retention)) {
+			continue
+		}
+
+		// Without the build ID among the labels several builds can share a series, TeamCity lists the latest build
+		// first and only that one is kept.
+		labels := buildLabelValues(build, collector.labels, identifier, name)
+		key := strings.Join(labels, "\x00")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		// Timestamp the metrics of finished builds with their finish time when enabled, so that they line up with when
+		// the build happened rather than with the scrape.
+		stamp := func(metric prometheus.Metric) prometheus.Metric {
+			if !timestamps || tcapi.ParseBuildState(build.State) != tcapi.BuildFinished || build.FinishDate.IsZero() {
+				return metric
+			}
+			return prometheus.NewMetricWithTimestamp(build.FinishDate.Time, metric)
+		}
 
 		// Set the build start time metric.
-		ch <- prometheus.MustNewConstMetric(
+		ch <- stamp(prometheus.MustNewConstMetric(
 			collector.buildStartTime,
 			prometheus.GaugeValue,
 			float64(build.StartDate.Unix()),
 			labels...,
+		))
+
+		// Set the build finish time metric, running builds have not finished yet.
+		if !build.FinishDate.IsZero() {
+			ch <- stamp(prometheus.MustNewConstMetric(
+				collector.buildFinishTime,
+				prometheus.GaugeValue,
+				float64(build.FinishDate.Unix()),
+				labels...,
+			))
+		}
+
+		// Set the build duration metric, running builds report the time they have been running for so far.
+		if elapsed := build.Elapsed(collector.now()); elapsed > 0 {
+			ch <- stamp(prometheus.MustNewConstMetric(
+				collector.buildDuration,
+				prometheus.GaugeValue,
+				elapsed.Seconds(),
+				labels...,
+			))
+		}
+
+		// Set the build queue wait metric, builds missing their queued or start date are skipped.
+		if !build.QueuedDate.IsZero() && !build.StartDate.IsZero() {
+			ch <- stamp(prometheus.MustNewConstMetric(
+				collector.buildQueueWait,
+				prometheus.GaugeValue,
+				build.QueueWait().Seconds(),
+				labels...,
+			))
+		}
+
+		if viper.GetBool("builds.one_hot") {
+			// Set the build "status" and "state" metrics, one series per possible value with the current one set.
+			status := tcapi.ParseBuildStatus(build.Status)
+			for value, name := range buildStatusNames {
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildStatus,
+					prometheus.GaugeValue,
+					float64(map[bool]int{true: 1, false: 0}[tcapi.BuildStatus(value) == status]),
+					append(labels, name)...,
+				))
+			}
+
+			state := tcapi.ParseBuildState(build.State)
+			for value, name := range buildStateNames {
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildState,
+					prometheus.GaugeValue,
+					float64(map[bool]int{true: 1, false: 0}[tcapi.BuildState(value) == state]),
+					append(labels, name)...,
+				))
+			}
+		} else {
+			// Set the build "status" metric.
+			ch <- stamp(prometheus.MustNewConstMetric(
+				collector.buildStatus,
+				prometheus.GaugeValue,
+				float64(tcapi.ParseBuildStatus(build.Status)),
+				labels...,
+			))
+
+			// Set the build "state" metric.
+			ch <- stamp(prometheus.MustNewConstMetric(
+				collector.buildState,
+				prometheus.GaugeValue,
+				float64(tcapi.ParseBuildState(build.State)),
+				labels...,
+			))
+		}
+
+		// Set the test and problem count metrics, running builds have no final numbers to report yet.
+		if tcapi.ParseBuildState(build.State) == tcapi.BuildFinished {
+			if build.TestOccurrences != nil {
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildTests,
+					prometheus.GaugeValue,
+					float64(build.TestOccurrences.Count),
+					labels...,
+				))
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildTestsFailed,
+					prometheus.GaugeValue,
+					float64(build.TestOccurrences.Failed),
+					labels...,
+				))
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildTestsIgnored,
+					prometheus.GaugeValue,
+					float64(build.TestOccurrences.Ignored),
+					labels...,
+				))
+			}
+
+			ch <- stamp(prometheus.MustNewConstMetric(
+				collector.buildProblems,
+				prometheus.GaugeValue,
+				float64(build.ProblemOccurrences.Count),
+				labels...,
+			))
+
+			// Set the artifact metrics, they are only fetched when collecting artifacts is enabled.
+			if build.Artifacts != nil {
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildArtifacts,
+					prometheus.GaugeValue,
+					float64(build.Artifacts.Count),
+					labels...,
+				))
+			}
+			if size, ok := build.ArtifactsSize(); ok {
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildArtifactSize,
+					prometheus.GaugeValue,
+					size,
+					labels...,
+				))
+			}
+		}
+
+		// Set the build classification metrics, only builds with the flag set are reported so that queries can filter
+		// them out with "unless" without extra series for every other build.
+		if build.Composite {
+			ch <- stamp(prometheus.MustNewConstMetric(collector.buildComposite, prometheus.GaugeValue, 1, labels...))
+		}
+		if build.Personal {
+			ch <- stamp(prometheus.MustNewConstMetric(collector.buildPersonal, prometheus.GaugeValue, 1, labels...))
+		}
+		if build.Canceled() {
+			ch <- stamp(prometheus.MustNewConstMetric(collector.buildCanceled, prometheus.GaugeValue, 1, labels...))
+		}
+
+		// Set the build timeout metric, only failed builds that hit their execution timeout are reported.
+		if build.TimedOut() {
+			ch <- stamp(prometheus.MustNewConstMetric(
+				collector.buildTimeout,
+				prometheus.GaugeValue,
+				1,
+				build.BuildTypeID, fmt.Sprintf("%d", build.ID),
+			))
+		}
+
+		// Set the build annotation metric, builds whose comment does not match the regex are skipped.
+		if collector.annotator != nil {
+			if values, ok := collector.annotator.Annotate(build.Comment.Text); ok {
+				ch <- stamp(prometheus.MustNewConstMetric(
+					collector.buildAnnotation,
+					prometheus.GaugeValue,
+					1,
+					append(labels, values...)...,
+				))
+			}
+		}
+	}
+
+	// Set the top failure reason metric for each of the project's build types.
+	for buildType, reason := range TopFailureReasons(builds.Builds, scrape.since) {
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeTopFailureReason,
+			prometheus.GaugeValue,
+			1,
+			buildType, reason,
 		)
+	}
 
-		// Set the build finish time metric.
+	// Set the average queue wait metric for each of the project's build types.
+	for buildType, wait := range AverageQueueWaits(builds.Builds, scrape.since) {
 		ch <- prometheus.MustNewConstMetric(
-			collector.buildFinishTime,
+			collector.buildTypeAvgQueueSeconds,
 			prometheus.GaugeValue,
-			float64(build.FinishDate.Unix()),
-			labels...,
+			wait.Seconds(),
+			buildType,
 		)
+	}
 
-		// Set the build "status" metric.
+	// Set the last build status metric for each of the project's build types.
+	for buildType, build := range LastBuilds(builds.Builds) {
 		ch <- prometheus.MustNewConstMetric(
-			collector.buildStatus,
+			collector.buildTypeLastBuildStatus,
 			prometheus.GaugeValue,
-			float64(ParseBuildStatus(build.Status)),
-			labels...,
+			float64(tcapi.ParseBuildStatus(build.Status)),
+			buildType, build.State,
 		)
+	}
 
-		// Set the build "state" metric.
+	// Set the staleness metric for each of the project's build types that finished a build before.
+	threshold := collector.now().Add(-viper.GetDuration("builds.staleness_threshold"))
+	for buildType, finished := range LatestFinishDates(builds.Builds) {
 		ch <- prometheus.MustNewConstMetric(
-			collector.buildState,
+			collector.buildTypeStale,
 			prometheus.GaugeValue,
-			float64(ParseBuildState(build.State)),
-			labels...,
+			float64(map[bool]int{true: 1, false: 0}[finished.Before(threshold)]),
+			buildType,
+		)
+	}
+
+	// Set the last successful build metric for each of the project's build types that succeeded before.
+	for buildType, finished := range LastSuccessDates(builds.Builds) {
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeLastSuccess,
+			prometheus.GaugeValue,
+			float64(finished.Unix()),
+			buildType,
+		)
+	}
+
+	// Set the consecutive failures metric for each of the project's build types that finished a build before.
+	for buildType, failures := range ConsecutiveFailures(builds.Builds) {
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeFailures,
+			prometheus.GaugeValue,
+			float64(failures),
+			buildType,
 		)
 	}
 
-	// Check for another request that we need to make to continue to get builds.
-	if builds.NextHRef != "" {
-		logger.Fatal("multipage requests are not yet supported")
+	// Set the error lines metric for the latest failed build of each build type, reading build logs is expensive.
+	if viper.GetBool("builds.collect_error_lines") {
+		for buildType, build := range LatestFailedBuilds(builds.Builds) {
+			err := collector.collectBuildErrorLines(ctx, buildType, build.ID, ch)
+			if err != nil {
+				logger.WithFields(logrus.Fields{"build": build.ID}).Error(err)
+				countScrapeError(collector.server, "builds")
+			}
+		}
+	}
+
+	// Set the chain duration metric for the latest chain build of each build type, walking the chains is expensive.
+	if viper.GetBool("builds.collect_chain_durations") {
+		for _, build := range LatestChainBuilds(builds.Builds) {
+			err := collector.collectBuildChainDuration(ctx, build, ch)
+			if err != nil {
+				logger.WithFields(logrus.Fields{"build": build.ID}).Error(err)
+				countScrapeError(collector.server, "builds")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (collector *TeamCityBuildsCollector) collectBuildChainDuration(ctx context.Context, top Build, ch chan<- prometheus.Metric) error {
+	// List every build of the chain, the top build included, whatever its branch or whether it was personal.
+	locator := fmt.Sprintf("snapshotDependency:(to:(id:%d),includeInitial:true),defaultFilter:false,count:%d", top.ID, viper.GetUint("page.count"))
+	chain, err := collector.api.ListBuilds(ctx, locator, "id,queuedDate,startDate,finishDate", 0)
+	if err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.buildChain,
+		prometheus.GaugeValue,
+		ChainDuration(top, chain).Seconds(),
+		top.BuildTypeID, fmt.Sprintf("%d", top.ID),
+	)
+
+	return nil
+}
+
+// collectBuildErrorLines counts the error messages of a build's log. The REST API does not list build log messages, so
+// they are read from the undocumented endpoint of the web UI, which is why collecting them is opt-in and unsupported.
+func (collector *TeamCityBuildsCollector) collectBuildErrorLines(ctx context.Context, buildType string, build uint64, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/messages?buildId=%d&filter=errors",
+		collector.addr,
+		build,
+	)
+
+	messages := MessagesResponse{}
+	err := tcapi.GetJSON(ctx, collector.api.HTTPClient, url, &messages)
+	if err != nil {
+		return err
 	}
 
+	ch <- prometheus.MustNewConstMetric(
+		collector.buildErrorLines,
+		prometheus.GaugeValue,
+		float64(messages.ErrorLines()),
+		buildType, fmt.Sprintf("%d", build),
+	)
+
 	return nil
 }
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newBuildsTestCollector returns a builds collector of the root project alone, listing the given builds, at noon on
+// the first of January 2024.
+func newBuildsTestCollector(t *testing.T, builds string, config map[string]interface{}) *TeamCityBuildsCollector {
+	t.Helper()
+	config["root.project.id"] = "_Root"
+	setConfig(t, config)
+	server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/builds": builds}))
+
+	collector := NewTeamCityBuildsCollector(server)
+	collector.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+	return collector
+}
+
+func TestBuildsCollector(t *testing.T) {
+	collector := newBuildsTestCollector(t, fixture(t, "builds.json"), map[string]interface{}{"builds.since": "24h"})
+
+	expected := `
+# HELP teamcity_build_duration_seconds The duration of a finished TeamCity build job, or the elapsed time of a running one.
+# TYPE teamcity_build_duration_seconds gauge
+teamcity_build_duration_seconds{branch="feature",build_id="3",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 120
+teamcity_build_duration_seconds{branch="main",build_id="1",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 300
+teamcity_build_duration_seconds{branch="main",build_id="2",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 600
+# HELP teamcity_build_state The state of a TeamCity build job.
+# TYPE teamcity_build_state gauge
+teamcity_build_state{branch="feature",build_id="3",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 3
+teamcity_build_state{branch="main",build_id="1",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 2
+teamcity_build_state{branch="main",build_id="2",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 2
+# HELP teamcity_build_status The status of a TeamCity build job.
+# TYPE teamcity_build_status gauge
+teamcity_build_status{branch="feature",build_id="3",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 1
+teamcity_build_status{branch="main",build_id="1",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 1
+teamcity_build_status{branch="main",build_id="2",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 2
+# HELP teamcity_build_tests_failed The number of failed tests of a finished TeamCity build job.
+# TYPE teamcity_build_tests_failed gauge
+teamcity_build_tests_failed{branch="main",build_id="2",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 2
+# HELP teamcity_project_agent_seconds The agent time consumed by the builds of a top-level TeamCity project within the builds window.
+# TYPE teamcity_project_agent_seconds gauge
+teamcity_project_agent_seconds{project_id="_Root"} 900
+`
+	err := testutil.CollectAndCompare(
+		collector,
+		strings.NewReader(expected),
+		"teamcity_build_duration_seconds",
+		"teamcity_build_state",
+		"teamcity_build_status",
+		"teamcity_build_tests_failed",
+		"teamcity_project_agent_seconds",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildsCollectorForgetsObservedBuilds(t *testing.T) {
+	builds := `{"count": 2, "build": [
+		{"id": 2, "buildTypeId": "Recent", "state": "finished", "status": "SUCCESS", "startDate": "20240101T110000+0000", "finishDate": "20240101T113000+0000"},
+		{"id": 1, "buildTypeId": "Old", "state": "finished", "status": "SUCCESS", "startDate": "20231201T110000+0000", "finishDate": "20231201T113000+0000"}
+	]}`
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{"builds.since": "24h"})
+	now := collector.now()
+	collector.now = func() time.Time { return now }
+
+	testutil.CollectAndCount(collector)
+	if _, ok := collector.observedBuilds.Load(uint64(2)); !ok {
+		t.Error("build 2 finished within the window but was not observed")
+	}
+	if _, ok := collector.observedBuilds.Load(uint64(1)); ok {
+		t.Error("build 1 finished before the window but was observed")
+	}
+
+	now = now.Add(48 * time.Hour)
+	testutil.CollectAndCount(collector)
+	if _, ok := collector.observedBuilds.Load(uint64(2)); ok {
+		t.Error("build 2 finished before the window but is still remembered")
+	}
+}
+
+func TestParseBuildLabels(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"", defaultBuildLabels, false},
+		{"project_id, build_type_id,branch,branch", "project_id,build_type_id,branch", false},
+		{"project_id,build_type_id,unknown", "", true},
+		{"build_type_id,branch", "", true},
+		{"project_id,branch", "", true},
+	}
+
+	for _, test := range tests {
+		labels, err := ParseBuildLabels(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("ParseBuildLabels(%q) error = %v", test.value, err)
+			continue
+		}
+		if got := strings.Join(labels, ","); got != test.want {
+			t.Errorf("ParseBuildLabels(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestBuildsCollectorTimeout(t *testing.T) {
+	builds := `{"count": 3, "build": [
+		{"id": 3, "buildTypeId": "Slow", "state": "finished", "status": "FAILURE", "startDate": "20240101T100000+0000", "finishDate": "20240101T110000+0000",
+			"problemOccurrences": {"count": 1, "problemOccurrence": [{"type": "TC_EXECUTION_TIMEOUT"}]}},
+		{"id": 2, "buildTypeId": "Broken", "state": "finished", "status": "FAILURE", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000",
+			"problemOccurrences": {"count": 1, "problemOccurrence": [{"type": "TC_COMPILATION_ERROR"}]}},
+		{"id": 1, "buildTypeId": "Slow", "state": "finished", "status": "SUCCESS", "startDate": "20240101T080000+0000", "finishDate": "20240101T090000+0000",
+			"problemOccurrences": {"count": 1, "problemOccurrence": [{"type": "TC_EXECUTION_TIMEOUT"}]}}
+	]}`
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{"builds.since": "24h"})
+
+	expected := `
+# HELP teamcity_build_timeout Whether a failed TeamCity build job exceeded its execution timeout.
+# TYPE teamcity_build_timeout gauge
+teamcity_build_timeout{build_id="3",build_type_id="Slow"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_build_timeout")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildsCollectorAgentSeconds(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root", "builds.since": "24h"})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds": `{"count": 0}`,
+		"/app/rest/builds?project:id:Team,": `{"count": 2, "build": [
+			{"id": 1, "buildTypeId": "Team_Build", "state": "finished", "status": "SUCCESS", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000"},
+			{"id": 2, "buildTypeId": "Team_Build", "state": "finished", "status": "SUCCESS", "startDate": "20231230T100000+0000", "finishDate": "20231230T101000+0000"}
+		]}`,
+		"/app/rest/builds?project:id:Team_Sub,": `{"count": 1, "build": [
+			{"id": 3, "buildTypeId": "Team_Sub_Build", "state": "finished", "status": "FAILURE", "startDate": "20240101T110000+0000", "finishDate": "20240101T110500+0000"}
+		]}`,
+	}))
+	projects := projectTree("Team", "Other")
+	projects["Team"].ChildProjects.Items = []*teamcity.ProjectReference{{ID: "Team_Sub", Name: "Sub"}}
+	projects["Team_Sub"] = &teamcity.Project{ID: "Team_Sub", Name: "Sub", ParentProjectID: "Team"}
+	server.API.Projects = projects
+
+	collector := NewTeamCityBuildsCollector(server)
+	collector.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+
+	// Only the builds that finished within the window count, those of subprojects towards their top-level project.
+	expected := `
+# HELP teamcity_project_agent_seconds The agent time consumed by the builds of a top-level TeamCity project within the builds window.
+# TYPE teamcity_project_agent_seconds gauge
+teamcity_project_agent_seconds{project_id="Other"} 0
+teamcity_project_agent_seconds{project_id="Team"} 900
+teamcity_project_agent_seconds{project_id="_Root"} 0
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_project_agent_seconds")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// decodeBuilds decodes a builds listing of the REST API.
+func decodeBuilds(t *testing.T, builds string) []Build {
+	t.Helper()
+	var response BuildResponse
+	if err := json.Unmarshal([]byte(builds), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Builds
+}
+
+func TestTopFailureReasons(t *testing.T) {
+	builds := decodeBuilds(t, `{"count": 5, "build": [
+		{"id": 5, "buildTypeId": "A", "status": "FAILURE", "finishDate": "20240101T110000+0000",
+			"problemOccurrences": {"problemOccurrence": [{"type": "TC_FAILED_TESTS"}, {"type": "TC_EXIT_CODE"}]}},
+		{"id": 4, "buildTypeId": "A", "status": "FAILURE", "finishDate": "20240101T100000+0000",
+			"problemOccurrences": {"problemOccurrence": [{"type": "TC_FAILED_TESTS"}]}},
+		{"id": 3, "buildTypeId": "A", "status": "SUCCESS", "finishDate": "20240101T090000+0000",
+			"problemOccurrences": {"problemOccurrence": [{"type": "TC_EXIT_CODE"}, {"type": "TC_EXIT_CODE"}]}},
+		{"id": 2, "buildTypeId": "A", "status": "FAILURE", "finishDate": "20231201T090000+0000",
+			"problemOccurrences": {"problemOccurrence": [{"type": "TC_EXIT_CODE"}, {"type": "TC_EXIT_CODE"}]}},
+		{"id": 1, "buildTypeId": "B", "status": "FAILURE", "finishDate": "20240101T090000+0000",
+			"problemOccurrences": {"problemOccurrence": [{"type": "TC_EXIT_CODE"}, {"type": "TC_COMPILATION_ERROR"}]}}
+	]}`)
+
+	// Successful builds and those that finished before the window are not tallied, ties go to the first reason by name.
+	reasons := TopFailureReasons(builds, time.Date(2023, 12, 31, 12, 0, 0, 0, time.UTC))
+	want := map[string]string{"A": "TC_FAILED_TESTS", "B": "TC_COMPILATION_ERROR"}
+	if len(reasons) != len(want) || reasons["A"] != want["A"] || reasons["B"] != want["B"] {
+		t.Errorf("TopFailureReasons() = %v, want %v", reasons, want)
+	}
+}
+
+func TestBuildsCollectorActiveUsers(t *testing.T) {
+	builds := `{"count": 5, "build": [
+		{"id": 5, "buildTypeId": "A", "state": "finished", "status": "SUCCESS", "startDate": "20240101T110000+0000", "finishDate": "20240101T111000+0000",
+			"triggered": {"type": "user", "user": {"username": "alice"}}},
+		{"id": 4, "buildTypeId": "B", "state": "finished", "status": "SUCCESS", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000",
+			"triggered": {"type": "user", "user": {"username": "bob"}}},
+		{"id": 3, "buildTypeId": "A", "state": "finished", "status": "SUCCESS", "startDate": "20240101T090000+0000", "finishDate": "20240101T091000+0000",
+			"triggered": {"type": "user", "user": {"username": "alice"}}},
+		{"id": 2, "buildTypeId": "A", "state": "finished", "status": "SUCCESS", "startDate": "20240101T080000+0000", "finishDate": "20240101T081000+0000",
+			"triggered": {"type": "vcs"}},
+		{"id": 1, "buildTypeId": "A", "state": "finished", "status": "SUCCESS", "startDate": "20231201T080000+0000", "finishDate": "20231201T081000+0000",
+			"triggered": {"type": "user", "user": {"username": "carol"}}}
+	]}`
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{"builds.since": "24h"})
+
+	// Alice is counted once, Carol's build started before the window.
+	expected := `
+# HELP teamcity_active_build_users The number of distinct users that triggered TeamCity builds within the builds window.
+# TYPE teamcity_active_build_users gauge
+teamcity_active_build_users 2
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_active_build_users")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildsCollectorErrorLines(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root", "builds.since": "24h", "builds.collect_error_lines": true})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds": `{"count": 3, "build": [
+			{"id": 3, "buildTypeId": "A", "state": "finished", "status": "FAILURE", "startDate": "20240101T110000+0000", "finishDate": "20240101T111000+0000"},
+			{"id": 2, "buildTypeId": "A", "state": "finished", "status": "FAILURE", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000"},
+			{"id": 1, "buildTypeId": "B", "state": "finished", "status": "SUCCESS", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000"}
+		]}`,
+		"/app/messages?buildId=3": `{"messages": [
+			{"id": 1, "text": "compiling", "status": 1},
+			{"id": 2, "text": "main.go:1: syntax error", "status": 4},
+			{"id": 3, "text": "main.go:2: syntax error", "status": 4},
+			{"id": 4, "text": "warning", "status": 2}
+		]}`,
+	}))
+	collector := NewTeamCityBuildsCollector(server)
+	collector.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+
+	expected := `
+# HELP teamcity_build_error_lines The number of error lines in the build log of the latest failed build of a TeamCity build type.
+# TYPE teamcity_build_error_lines gauge
+teamcity_build_error_lines{build_id="3",build_type_id="A"} 2
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_build_error_lines")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAverageQueueWaits(t *testing.T) {
+	builds := decodeBuilds(t, `{"count": 5, "build": [
+		{"id": 5, "buildTypeId": "A", "state": "running", "queuedDate": "20240101T110000+0000", "startDate": "20240101T113000+0000"},
+		{"id": 4, "buildTypeId": "A", "state": "finished", "queuedDate": "20240101T100000+0000", "startDate": "20240101T100100+0000", "finishDate": "20240101T101000+0000"},
+		{"id": 3, "buildTypeId": "A", "state": "finished", "queuedDate": "20240101T090000+0000", "startDate": "20240101T090300+0000", "finishDate": "20240101T091000+0000"},
+		{"id": 2, "buildTypeId": "A", "state": "finished", "queuedDate": "20231201T090000+0000", "startDate": "20231201T100000+0000", "finishDate": "20231201T101000+0000"},
+		{"id": 1, "buildTypeId": "B", "state": "finished", "startDate": "20240101T090000+0000", "finishDate": "20240101T091000+0000"}
+	]}`)
+
+	// The running build, the build that finished before the window, and the build missing its queued date are left out.
+	waits := AverageQueueWaits(builds, time.Date(2023, 12, 31, 12, 0, 0, 0, time.UTC))
+	if len(waits) != 1 || waits["A"] != 2*time.Minute {
+		t.Errorf("AverageQueueWaits() = %v, want 2m0s for A alone", waits)
+	}
+}
+
+func TestBuildsCollectorStaleBuildTypes(t *testing.T) {
+	builds := `{"count": 3, "build": [
+		{"id": 3, "buildTypeId": "Recent", "state": "finished", "status": "SUCCESS", "startDate": "20231225T100000+0000", "finishDate": "20231225T101000+0000"},
+		{"id": 2, "buildTypeId": "Stale", "state": "finished", "status": "SUCCESS", "startDate": "20231101T100000+0000", "finishDate": "20231101T101000+0000"},
+		{"id": 1, "buildTypeId": "Recent", "state": "finished", "status": "SUCCESS", "startDate": "20231001T100000+0000", "finishDate": "20231001T101000+0000"}
+	]}`
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{"builds.staleness_threshold": "720h"})
+
+	expected := `
+# HELP teamcity_build_type_stale Whether the latest build of a TeamCity build type finished longer ago than the staleness threshold.
+# TYPE teamcity_build_type_stale gauge
+teamcity_build_type_stale{build_type_id="Recent"} 0
+teamcity_build_type_stale{build_type_id="Stale"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_build_type_stale")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildsCollectorQueueWait(t *testing.T) {
+	builds := fixture(t, "builds.json")
+	builds = strings.Replace(builds, `"queuedDate": "20240101T095500+0000",`, "", 1)
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{"builds.since": "24h"})
+
+	// The build missing its queued date has no queue wait.
+	expected := `
+# HELP teamcity_build_queue_wait_seconds The time a TeamCity build job waited in the queue before starting.
+# TYPE teamcity_build_queue_wait_seconds gauge
+teamcity_build_queue_wait_seconds{branch="feature",build_id="3",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 180
+teamcity_build_queue_wait_seconds{branch="main",build_id="2",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 300
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_build_queue_wait_seconds")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildsCollectorRunningOnly(t *testing.T) {
+	builds := `{"count": 3, "build": [
+		{"id": 3, "buildTypeId": "New", "state": "running", "status": "SUCCESS", "startDate": "20240101T115800+0000"},
+		{"id": 2, "buildTypeId": "Old", "state": "running", "status": "FAILURE", "startDate": "20240101T115000+0000"},
+		{"id": 1, "buildTypeId": "Old", "state": "finished", "status": "SUCCESS", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000"}
+	]}`
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{"builds.since": "24h"})
+
+	// The build type whose only build is running still reports it, running builds report no finish time.
+	expected := `
+# HELP teamcity_build_finish_time The finish time of a TeamCity build job.
+# TYPE teamcity_build_finish_time gauge
+teamcity_build_finish_time{branch="",build_id="1",build_type_id="Old",project_id="_Root",project_name="<Root project>"} 1.7041038e+09
+# HELP teamcity_build_type_last_build_status The status of the last finished build of a TeamCity build type, or of its latest build when none finished.
+# TYPE teamcity_build_type_last_build_status gauge
+teamcity_build_type_last_build_status{build_type_id="New",state="running"} 1
+teamcity_build_type_last_build_status{build_type_id="Old",state="finished"} 1
+`
+	err := testutil.CollectAndCompare(
+		collector,
+		strings.NewReader(expected),
+		"teamcity_build_finish_time",
+		"teamcity_build_type_last_build_status",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildsCollectorCommentAnnotation(t *testing.T) {
+	builds := `{"count": 3, "build": [
+		{"id": 3, "buildTypeId": "Deploy", "state": "finished", "status": "SUCCESS", "startDate": "20240101T110000+0000", "finishDate": "20240101T111000+0000",
+			"comment": {"text": "deploy: prod v1.2.3"}},
+		{"id": 2, "buildTypeId": "Deploy", "state": "finished", "status": "SUCCESS", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000",
+			"comment": {"text": "hotfix, no deployment"}},
+		{"id": 1, "buildTypeId": "Deploy", "state": "finished", "status": "SUCCESS", "startDate": "20240101T090000+0000", "finishDate": "20240101T091000+0000"}
+	]}`
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{
+		"builds.since":         "24h",
+		"builds.comment_regex": `^deploy: (?P<environment>\w+) (?P<version>v[\d.]+)$`,
+	})
+
+	// Only the build whose comment matches is annotated.
+	expected := `
+# HELP teamcity_build_annotation The annotation extracted from the comment of a TeamCity build.
+# TYPE teamcity_build_annotation gauge
+teamcity_build_annotation{branch="",build_id="3",build_type_id="Deploy",environment="prod",project_id="_Root",project_name="<Root project>",version="v1.2.3"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_build_annotation")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewCommentAnnotator(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  bool
+	}{
+		{`deploy: (?P<environment>\w+)`, false},
+		{`deploy: (\w+)`, true},
+		{`deploy: (?P<branch>\w+)`, true},
+		{`deploy: (?P<environment>\w+`, true},
+	}
+
+	for _, test := range tests {
+		_, err := NewCommentAnnotator(test.expr)
+		if (err != nil) != test.err {
+			t.Errorf("NewCommentAnnotator(%q) error = %v", test.expr, err)
+		}
+	}
+}
+
+func TestLatestBuildsPerType(t *testing.T) {
+	builds := decodeBuilds(t, `{"count": 6, "build": [
+		{"id": 6, "buildTypeId": "A"},
+		{"id": 2, "buildTypeId": "A"},
+		{"id": 5, "buildTypeId": "B"},
+		{"id": 4, "buildTypeId": "A"},
+		{"id": 3, "buildTypeId": "B"},
+		{"id": 1, "buildTypeId": "B"}
+	]}`)
+
+	if latest := LatestBuildsPerType(builds, 0); latest != nil {
+		t.Errorf("LatestBuildsPerType(0) = %v, want every build kept", latest)
+	}
+
+	latest := LatestBuildsPerType(builds, 2)
+	for id, want := range map[uint64]bool{6: true, 5: true, 4: true, 3: true, 2: false, 1: false} {
+		if latest[id] != want {
+			t.Errorf("LatestBuildsPerType(2) keeps build %d = %v, want %v", id, latest[id], want)
+		}
+	}
+}
+
+func TestBuildsCollectorPerType(t *testing.T) {
+	collector := newBuildsTestCollector(t, fixture(t, "builds.json"), map[string]interface{}{"builds.since": "24h", "builds.per_type": 1})
+
+	// Only the latest build of the build type is reported.
+	expected := `
+# HELP teamcity_build_state The state of a TeamCity build job.
+# TYPE teamcity_build_state gauge
+teamcity_build_state{branch="feature",build_id="3",build_type_id="Team_Build",project_id="_Root",project_name="<Root project>"} 3
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_build_state")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildsCollectorTestAndProblemCounts(t *testing.T) {
+	builds := `{"count": 3, "build": [
+		{"id": 3, "buildTypeId": "A", "state": "running", "status": "FAILURE", "startDate": "20240101T115000+0000",
+			"testOccurrences": {"count": 4, "passed": 3, "failed": 1, "ignored": 0}, "problemOccurrences": {"count": 1}},
+		{"id": 2, "buildTypeId": "A", "state": "finished", "status": "FAILURE", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000",
+			"testOccurrences": {"count": 10, "passed": 6, "failed": 3, "ignored": 1}, "problemOccurrences": {"count": 2}},
+		{"id": 1, "buildTypeId": "A", "state": "finished", "status": "SUCCESS", "startDate": "20240101T090000+0000", "finishDate": "20240101T091000+0000"}
+	]}`
+	collector := newBuildsTestCollector(t, builds, map[string]interface{}{"builds.since": "24h"})
+
+	// The running build reports no counts, the finished build without tests only reports its problems.
+	expected := `
+# HELP teamcity_build_problems_total The total number of problems of a finished TeamCity build job.
+# TYPE teamcity_build_problems_total gauge
+teamcity_build_problems_total{branch="",build_id="1",build_type_id="A",project_id="_Root",project_name="<Root project>"} 0
+teamcity_build_problems_total{branch="",build_id="2",build_type_id="A",project_id="_Root",project_name="<Root project>"} 2
+# HELP teamcity_build_tests_failed The number of failed tests of a finished TeamCity build job.
+# TYPE teamcity_build_tests_failed gauge
+teamcity_build_tests_failed{branch="",build_id="2",build_type_id="A",project_id="_Root",project_name="<Root project>"} 3
+# HELP teamcity_build_tests_ignored The number of ignored tests of a finished TeamCity build job.
+# TYPE teamcity_build_tests_ignored gauge
+teamcity_build_tests_ignored{branch="",build_id="2",build_type_id="A",project_id="_Root",project_name="<Root project>"} 1
+# HELP teamcity_build_tests_total The total number of tests run by a finished TeamCity build job.
+# TYPE teamcity_build_tests_total gauge
+teamcity_build_tests_total{branch="",build_id="2",build_type_id="A",project_id="_Root",project_name="<Root project>"} 10
+`
+	err := testutil.CollectAndCompare(
+		collector,
+		strings.NewReader(expected),
+		"teamcity_build_problems_total",
+		"teamcity_build_tests_failed",
+		"teamcity_build_tests_ignored",
+		"teamcity_build_tests_total",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// gatherBuildDurations collects the builds collector into a registry and returns the duration histogram of the build
+// type.
+func gatherBuildDurations(t *testing.T, collector *TeamCityBuildsCollector, buildType string) *dto.Histogram {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "teamcity_build_type_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "build_type_id" && label.GetValue() == buildType {
+					return metric.GetHistogram()
+				}
+			}
+		}
+	}
+	t.Fatalf("no teamcity_build_type_duration_seconds histogram of build type %s", buildType)
+	return nil
+}
+
+func TestBuildsCollectorNativeHistograms(t *testing.T) {
+	collector := newBuildsTestCollector(t, fixture(t, "builds.json"), map[string]interface{}{
+		"builds.since":              "24h",
+		"metrics.native_histograms": true,
+	})
+
+	histogram := gatherBuildDurations(t, collector, "Team_Build")
+	if histogram.Schema == nil {
+		t.Error("the duration histogram has no native histogram schema")
+	}
+	if len(histogram.GetPositiveSpan()) == 0 || len(histogram.GetPositiveDelta()) == 0 {
+		t.Errorf("the duration histogram has no positive native buckets: %v", histogram)
+	}
+	if histogram.GetSampleCount() != 2 {
+		t.Errorf("sample count = %d, want the 2 finished builds", histogram.GetSampleCount())
+	}
+}
+
+func TestBuildsCollectorClassicHistograms(t *testing.T) {
+	collector := newBuildsTestCollector(t, fixture(t, "builds.json"), map[string]interface{}{"builds.since": "24h"})
+
+	histogram := gatherBuildDurations(t, collector, "Team_Build")
+	if histogram.Schema != nil || len(histogram.GetPositiveSpan()) != 0 || len(histogram.GetPositiveDelta()) != 0 {
+		t.Errorf("the duration histogram has native buckets without native histograms enabled: %v", histogram)
+	}
+	if len(histogram.GetBucket()) == 0 {
+		t.Error("the duration histogram has no classic buckets")
+	}
+}
+
+func TestBuildsCollectorPages(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root", "builds.since": "24h"})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds": `{"count": 1, "nextHref": "/app/rest/builds?locator=project:id:_Root,start:1", "build": [
+			{"id": 2, "buildTypeId": "A", "state": "finished", "status": "FAILURE", "startDate": "20240101T110000+0000", "finishDate": "20240101T111000+0000"}
+		]}`,
+		"/app/rest/builds?start:1": `{"count": 1, "build": [
+			{"id": 1, "buildTypeId": "A", "state": "finished", "status": "SUCCESS", "startDate": "20240101T100000+0000", "finishDate": "20240101T101000+0000"}
+		]}`,
+	}))
+	collector := NewTeamCityBuildsCollector(server)
+	collector.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+
+	// The builds of both pages are reported.
+	expected := `
+# HELP teamcity_build_status The status of a TeamCity build job.
+# TYPE teamcity_build_status gauge
+teamcity_build_status{branch="",build_id="1",build_type_id="A",project_id="_Root",project_name="<Root project>"} 1
+teamcity_build_status{branch="",build_id="2",build_type_id="A",project_id="_Root",project_name="<Root project>"} 2
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "teamcity_build_status")
+	if err != nil {
+		t.Error(err)
+	}
+}
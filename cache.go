@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+)
+
+var scrapeLockTimeouts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "teamcity_exporter_scrape_lock_timeouts_total",
+		Help: "The total number of scrapes that timed out waiting for a collection and served cached metrics.",
+	},
+	[]string{"server", "collector"},
+)
+
+var collectorDuration = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "teamcity_exporter_collector_duration_seconds",
+		Help: "The duration of the last collection of a collector.",
+	},
+	[]string{"server", "collector"},
+)
+
+var collectorLastScrape = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "teamcity_exporter_collector_last_scrape_timestamp",
+		Help: "The Unix timestamp at which the last collection of a collector finished.",
+	},
+	[]string{"server", "collector"},
+)
+
+// CachedCollector wraps a collector so that concurrent scrapes share a single in-flight collection, and keeps the
+// metrics of the last finished collection around to serve when waiting on a collection takes too long, or for the
+// TTL after it finished.
+type CachedCollector struct {
+	server    string
+	name      string
+	collector prometheus.Collector
+	timeout   time.Duration
+	ttl       time.Duration
+
+	mutex      sync.Mutex
+	metrics    []prometheus.Metric
+	collected  time.Time
+	inflight   chan struct{}
+	background bool
+}
+
+func NewCachedCollector(server string, name string, collector prometheus.Collector, timeout time.Duration, ttl time.Duration) *CachedCollector {
+	return &CachedCollector{
+		server:    server,
+		name:      name,
+		collector: collector,
+		timeout:   timeout,
+		ttl:       ttl,
+	}
+}
+
+func (cached *CachedCollector) Describe(ch chan<- *prometheus.Desc) {
+	cached.collector.Describe(ch)
+}
+
+func (cached *CachedCollector) Collect(ch chan<- prometheus.Metric) {
+	logger := logrus.WithFields(logrus.Fields{"collector": cached.name})
+
+	// Serve the metrics of the last collection while they are fresh, a zero TTL collects on every scrape. When
+	// refreshed in the background the metrics are always served once there are any.
+	cached.mutex.Lock()
+	fresh := cached.background || (cached.ttl > 0 && time.Since(cached.collected) < cached.ttl)
+	if fresh && !cached.collected.IsZero() {
+		metrics := cached.metrics
+		cached.mutex.Unlock()
+
+		logger.Debug("serving cached metrics")
+		for _, metric := range metrics {
+			ch <- metric
+		}
+		return
+	}
+
+	done := cached.start(logger)
+	cached.mutex.Unlock()
+
+	// A zero timeout waits for the collection to finish no matter how long it takes.
+	var timeout <-chan time.Time
+	if cached.timeout > 0 {
+		timer := time.NewTimer(cached.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-done:
+	case <-timeout:
+		logger.WithFields(logrus.Fields{"timeout": cached.timeout}).Warn("timed out waiting for collection, serving cached metrics")
+		scrapeLockTimeouts.WithLabelValues(cached.server, cached.name).Inc()
+	}
+
+	cached.mutex.Lock()
+	metrics := cached.metrics
+	cached.mutex.Unlock()
+
+	for _, metric := range metrics {
+		ch <- metric
+	}
+}
+
+// start joins the in-flight collection if there is one, otherwise it starts a new one. It returns a channel closed once
+// the collection finished, the mutex must be held.
+func (cached *CachedCollector) start(logger *logrus.Entry) chan struct{} {
+	if cached.inflight != nil {
+		logger.Debug("joining in-flight collection")
+		return cached.inflight
+	}
+
+	done := make(chan struct{})
+	cached.inflight = done
+	go cached.collect(done)
+	return done
+}
+
+// Refresh collects every interval until ctx is done, from then on scrapes serve the metrics of the last finished
+// collection instead of collecting themselves. Scrapes before the first collection finished still wait on it.
+func (cached *CachedCollector) Refresh(ctx context.Context, interval time.Duration) {
+	logger := logrus.WithFields(logrus.Fields{"collector": cached.name})
+
+	cached.mutex.Lock()
+	cached.background = true
+	cached.mutex.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		logger.Debug("refreshing collection in the background")
+		cached.mutex.Lock()
+		done := cached.start(logger)
+		cached.mutex.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (cached *CachedCollector) collect(done chan struct{}) {
+	errors := scrapeErrorCount(cached.server, cached.name)
+	start := time.Now()
+
+	results := make(chan prometheus.Metric)
+	go func() {
+		defer close(results)
+		defer recoverCollectorPanic(cached.server, cached.name)
+		cached.collector.Collect(results)
+	}()
+
+	metrics := []prometheus.Metric{}
+	for metric := range results {
+		metrics = append(metrics, metric)
+	}
+
+	// Any error counted while collecting fails the collection, errors of overlapping /collect requests included.
+	success := scrapeErrorCount(cached.server, cached.name) == errors
+	scrapeSuccess.WithLabelValues(cached.server, cached.name).Set(float64(map[bool]int{true: 1, false: 0}[success]))
+
+	// A failed collection that still returned metrics is missing some of them, e.g. those of a failed subproject.
+	partial := !success && len(metrics) > 0
+	partialScrape.WithLabelValues(cached.server, cached.name).Set(float64(map[bool]int{true: 1, false: 0}[partial]))
+
+	// Set the collection duration and timestamp metrics, scrapes joining an in-flight collection share them.
+	finished := time.Now()
+	collectorDuration.WithLabelValues(cached.server, cached.name).Set(finished.Sub(start).Seconds())
+	collectorLastScrape.WithLabelValues(cached.server, cached.name).Set(float64(finished.Unix()))
+
+	cached.mutex.Lock()
+	cached.metrics = metrics
+	cached.collected = time.Now()
+	cached.inflight = nil
+	cached.mutex.Unlock()
+
+	close(done)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// gatedCollector counts its collections in a gauge of the given name, each collection waits to receive from gate
+// first.
+type gatedCollector struct {
+	desc        *prometheus.Desc
+	gate        chan struct{}
+	collections int32
+}
+
+func newGatedCollector(name string) *gatedCollector {
+	return &gatedCollector{
+		desc: prometheus.NewDesc(name, "The number of collections so far.", nil, nil),
+		gate: make(chan struct{}, 1),
+	}
+}
+
+func (collector *gatedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.desc
+}
+
+func (collector *gatedCollector) Collect(ch chan<- prometheus.Metric) {
+	<-collector.gate
+	collections := atomic.AddInt32(&collector.collections, 1)
+	ch <- prometheus.MustNewConstMetric(collector.desc, prometheus.GaugeValue, float64(collections))
+}
+
+func TestCachedCollectorLockTimeout(t *testing.T) {
+	collector := newGatedCollector("teamcity_test_collections")
+	t.Cleanup(func() { close(collector.gate) })
+	cached := NewCachedCollector("test", "lock_timeout", collector, 20*time.Millisecond, 0)
+	timeouts := scrapeLockTimeouts.WithLabelValues("test", "lock_timeout")
+	before := testutil.ToFloat64(timeouts)
+
+	collector.gate <- struct{}{}
+	if got := testutil.ToFloat64(cached); got != 1 {
+		t.Fatalf("first collection = %v, want 1", got)
+	}
+
+	// The second collection is stuck until the gate opens, the scrape serves the first collection's metrics.
+	if got := testutil.ToFloat64(cached); got != 1 {
+		t.Errorf("stuck collection = %v, want the cached 1", got)
+	}
+	if got := testutil.ToFloat64(timeouts) - before; got != 1 {
+		t.Errorf("teamcity_exporter_scrape_lock_timeouts_total increased by %v, want 1", got)
+	}
+
+	// A scrape joining the stuck collection gets its metrics once it finishes.
+	collector.gate <- struct{}{}
+	if got := testutil.ToFloat64(cached); got != 2 {
+		t.Errorf("joined collection = %v, want 2", got)
+	}
+}
+
+func TestCachedCollectorDurationMetrics(t *testing.T) {
+	collector := newGatedCollector("teamcity_test_collections")
+	cached := NewCachedCollector("test", "duration", collector, 0, 0)
+
+	start := time.Now()
+	time.AfterFunc(20*time.Millisecond, func() { collector.gate <- struct{}{} })
+	testutil.CollectAndCount(cached)
+	end := time.Now()
+
+	duration := testutil.ToFloat64(collectorDuration.WithLabelValues("test", "duration"))
+	if duration < 0.02 || duration > end.Sub(start).Seconds() {
+		t.Errorf("teamcity_exporter_collector_duration_seconds = %v, want the time the collection took", duration)
+	}
+	timestamp := testutil.ToFloat64(collectorLastScrape.WithLabelValues("test", "duration"))
+	if timestamp < float64(start.Unix()) || timestamp > float64(end.Unix()) {
+		t.Errorf("teamcity_exporter_collector_last_scrape_timestamp = %v, want the time the collection finished", timestamp)
+	}
+}
+
+func TestCachedCollectorTTL(t *testing.T) {
+	var requests int32
+	routes := jsonRoutes(map[string]string{"/app/rest/agents": fixture(t, "agents.json")})
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/rest/agents" {
+			atomic.AddInt32(&requests, 1)
+		}
+		routes(w, r)
+	}))
+	cached := NewCachedCollector(server.Name, "agents", NewTeamCityAgentCollector(server), 0, 200*time.Millisecond)
+
+	testutil.CollectAndCount(cached)
+	testutil.CollectAndCount(cached)
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("%d agents requests for two scrapes within the TTL, want 1", got)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	testutil.CollectAndCount(cached)
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("%d agents requests after a scrape past the TTL, want 2", got)
+	}
+}
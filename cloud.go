@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+type CloudProfile struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name,omitempty"`
+	CloudProviderID string            `json:"cloudProviderId,omitempty"`
+	Project         *ProjectReference `json:"project,omitempty"`
+}
+
+type CloudProfilesResponse struct {
+	Page
+	CloudProfiles []CloudProfile `json:"cloudProfile"`
+}
+
+type CloudImage struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name,omitempty"`
+	Profile *CloudProfile `json:"profile,omitempty"`
+}
+
+type CloudInstance struct {
+	ID    string     `json:"id"`
+	Name  string     `json:"name"`
+	State string     `json:"state"`
+	Image CloudImage `json:"image,omitempty"`
+}
+
+// NormalizedState returns the state of a cloud instance as a label value, e.g. "scheduled_to_start".
+func (instance CloudInstance) NormalizedState() string {
+	if instance.State == "" {
+		return "unknown"
+	}
+	return strings.ReplaceAll(strings.ToLower(instance.State), " ", "_")
+}
+
+// Failed reports whether a cloud instance is in one of the error states, e.g. after it failed to start.
+func (instance CloudInstance) Failed() bool {
+	return strings.HasPrefix(instance.NormalizedState(), "error")
+}
+
+type CloudInstancesResponse struct {
+	Page
+	CloudInstances []CloudInstance `json:"cloudInstance"`
+}
+
+type TeamCityCloudCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+	root   string
+	filter *ProjectFilter
+
+	cloudProfileInfo    *prometheus.Desc
+	cloudInstances      *prometheus.Desc
+	cloudInstanceErrors *prometheus.Desc
+}
+
+func NewTeamCityCloudCollector(server *Server) *TeamCityCloudCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityCloudCollector{
+		// Set the TeamCity client and address, and the project to collect.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+		root:   viper.GetString("root.project.id"),
+		filter: server.Filter,
+
+		// Cloud metric descriptions.
+		cloudProfileInfo: prometheus.NewDesc(
+			"teamcity_cloud_profile_info",
+			"Information about a TeamCity cloud profile.",
+			[]string{"profile_id", "name", "project_id", "provider"},
+			constLabels,
+		),
+		cloudInstances: prometheus.NewDesc(
+			"teamcity_cloud_instances",
+			"The number of instances of a TeamCity cloud profile by state.",
+			[]string{"profile_id", "state"},
+			constLabels,
+		),
+		cloudInstanceErrors: prometheus.NewDesc(
+			"teamcity_cloud_instance_errors",
+			"The number of instances of a TeamCity cloud profile in an error state.",
+			[]string{"profile_id"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityCloudCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.cloudProfileInfo
+	ch <- collector.cloudInstances
+	ch <- collector.cloudInstanceErrors
+}
+
+func (collector TeamCityCloudCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity cloud metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectCloudMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "cloud")
+	}
+}
+
+func (collector *TeamCityCloudCollector) collectCloudMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	locator := neturl.QueryEscape(fmt.Sprintf("affectedProject:(id:%s)", collector.root))
+
+	// Follow the next page links until all cloud profiles are gathered.
+	profiles := CloudProfilesResponse{}
+	err := tcapi.GetPages(
+		ctx,
+		collector.api.HTTPClient,
+		fmt.Sprintf("%s/app/rest/cloud/profiles?locator=%s&fields=count,nextHref,cloudProfile(id,name,cloudProviderId,project(id))", collector.addr, locator),
+		func(page CloudProfilesResponse) {
+			profiles.CloudProfiles = append(profiles.CloudProfiles, page.CloudProfiles...)
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Follow the next page links until all cloud instances are gathered.
+	instances := CloudInstancesResponse{}
+	err = tcapi.GetPages(
+		ctx,
+		collector.api.HTTPClient,
+		fmt.Sprintf("%s/app/rest/cloud/instances?locator=%s&fields=count,nextHref,cloudInstance(id,name,state,image(id,profile(id)))", collector.addr, locator),
+		func(page CloudInstancesResponse) {
+			instances.CloudInstances = append(instances.CloudInstances, page.CloudInstances...)
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"profiles": len(profiles.CloudProfiles), "instances": len(instances.CloudInstances)}).Info("found cloud profiles")
+
+	// The profiles of projects excluded by the project filter are dropped, along with their instances.
+	included, err := collector.filter.IncludedProjects(ctx, collector.api, collector.root)
+	if err != nil {
+		return err
+	}
+
+	// Count the instances of each profile by state, and those in an error state.
+	states := map[string]map[string]int{}
+	errors := map[string]int{}
+	for _, instance := range instances.CloudInstances {
+		if instance.Image.Profile == nil {
+			continue
+		}
+
+		profile := instance.Image.Profile.ID
+		if states[profile] == nil {
+			states[profile] = map[string]int{}
+		}
+		states[profile][instance.NormalizedState()]++
+		if instance.Failed() {
+			errors[profile]++
+		}
+	}
+
+	for _, profile := range profiles.CloudProfiles {
+		project := ""
+		if profile.Project != nil {
+			project = profile.Project.ID
+		}
+		if included != nil && !included[project] {
+			continue
+		}
+
+		// Set the cloud profile info metric.
+		ch <- prometheus.MustNewConstMetric(
+			collector.cloudProfileInfo,
+			prometheus.GaugeValue,
+			1,
+			profile.ID, profile.Name, project, profile.CloudProviderID,
+		)
+
+		// Set the cloud instances metric for each state the profile has instances in.
+		for state, count := range states[profile.ID] {
+			ch <- prometheus.MustNewConstMetric(
+				collector.cloudInstances,
+				prometheus.GaugeValue,
+				float64(count),
+				profile.ID, state,
+			)
+		}
+
+		// Set the cloud instance errors metric, profiles without failed instances report zero.
+		ch <- prometheus.MustNewConstMetric(
+			collector.cloudInstanceErrors,
+			prometheus.GaugeValue,
+			float64(errors[profile.ID]),
+			profile.ID,
+		)
+	}
+
+	return nil
+}
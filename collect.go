@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// rootedCollectorFactories maps the names of the collectors that can be rooted at another project than the configured
+// root project to their constructors.
+var rootedCollectorFactories = map[string]func(server *Server, project string) prometheus.Collector{
+	"builds": func(server *Server, project string) prometheus.Collector {
+		collector := NewTeamCityBuildsCollector(server)
+		collector.root = project
+		return collector
+	},
+	"cloud": func(server *Server, project string) prometheus.Collector {
+		collector := NewTeamCityCloudCollector(server)
+		collector.root = project
+		return collector
+	},
+	"problems": func(server *Server, project string) prometheus.Collector {
+		collector := NewTeamCityProblemsCollector(server)
+		collector.root = project
+		return collector
+	},
+	"projects": func(server *Server, project string) prometheus.Collector {
+		collector := NewTeamCityProjectsCollector(server)
+		collector.root = project
+		return collector
+	},
+	"statistics": func(server *Server, project string) prometheus.Collector {
+		collector := NewTeamCityStatisticsCollector(server)
+		collector.root = project
+		return collector
+	},
+}
+
+// CollectHandler runs a one-shot collection of the project rooted collectors for the project subtree given by the
+// project query parameter, so teams can scrape their own projects without a dedicated exporter. The collector query
+// parameter narrows the collection down to some of them, and the server query parameter picks the server to collect
+// from when there are several.
+type CollectHandler struct {
+	servers    []*Server
+	collectors []string
+}
+
+func NewCollectHandler(servers []*Server, collectors []string) *CollectHandler {
+	return &CollectHandler{
+		servers:    servers,
+		collectors: collectors,
+	}
+}
+
+// requestedCollectors returns the enabled project rooted collectors a request asks for, all of them when it names none.
+func (handler *CollectHandler) requestedCollectors(r *http.Request) ([]string, error) {
+	requested := map[string]bool{}
+	for _, value := range r.URL.Query()["collector"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				requested[name] = true
+			}
+		}
+	}
+
+	names := []string{}
+	for _, name := range handler.collectors {
+		if _, ok := rootedCollectorFactories[name]; !ok {
+			continue
+		}
+		if len(requested) == 0 || requested[name] {
+			names = append(names, name)
+			delete(requested, name)
+		}
+	}
+	for name := range requested {
+		return nil, fmt.Errorf("collector %q is not enabled or cannot be rooted at a project", name)
+	}
+
+	return names, nil
+}
+
+func (handler *CollectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("project")
+	if project == "" {
+		http.Error(w, "missing project query parameter", http.StatusBadRequest)
+		return
+	}
+
+	server, err := SelectServer(handler.servers, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	names, err := handler.requestedCollectors(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger := logrus.WithFields(logrus.Fields{"server": server.Name, "project": project, "collectors": names})
+	logger.Info("collecting project subtree on demand")
+
+	// Only the collectors that are rooted at a project can be rooted at another project.
+	registry := prometheus.NewRegistry()
+	for _, name := range names {
+		registry.MustRegister(rootedCollectorFactories[name](server, project))
+	}
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// MultiTargetHandler serves scrapes that name a project, e.g. "/metrics?project=MyProject&collector=builds", like the
+// collect endpoint and every other scrape with the exporter's own metrics. This lets several Prometheus jobs shard the
+// project tree between them, following the multi-target exporter pattern.
+func MultiTargetHandler(metrics http.Handler, collect http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("project") {
+			collect.ServeHTTP(w, r)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
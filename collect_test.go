@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cvbarros/go-teamcity/teamcity"
+)
+
+func TestCollectHandler(t *testing.T) {
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds":     `{"count": 0}`,
+		"/app/rest/buildTypes": `{"count": 0}`,
+	}))
+	projects := projectTree("Team", "Other")
+	projects["Team"].ChildProjects = teamcity.ProjectsReferences{Count: 1, Items: []*teamcity.ProjectReference{{ID: "Team_Sub"}}}
+	projects["Team_Sub"] = &teamcity.Project{ID: "Team_Sub", Name: "Sub", ParentProjectID: "Team"}
+	server.API.Projects = projects
+	handler := NewCollectHandler([]*Server{server}, []string{"agents", "builds", "projects"})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/collect?project=Team&collector=projects", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+
+	// The subtree of the requested project is collected, the rest of the project tree is not.
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`teamcity_projects_total{project_id="Team",project_name="Team"} 1`,
+		`teamcity_projects_total{project_id="Team_Sub",project_name="Sub"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %s", want)
+		}
+	}
+	if strings.Contains(body, `project_id="_Root"`) || strings.Contains(body, `project_id="Other"`) || strings.Contains(body, "teamcity_build_state") {
+		t.Errorf("body holds metrics beyond the projects of the subtree:\n%s", body)
+	}
+}
+
+func TestCollectHandlerBadRequest(t *testing.T) {
+	handler := NewCollectHandler([]*Server{{Name: "test"}}, []string{"agents", "projects"})
+
+	for _, target := range []string{
+		"/collect",
+		"/collect?project=Team&collector=agents",
+		"/collect?project=Team&collector=builds",
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
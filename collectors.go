@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectorFactories maps the names accepted by the collectors configuration to their constructors.
+var collectorFactories = map[string]func(server *Server) prometheus.Collector{
+	"agents": func(server *Server) prometheus.Collector {
+		return NewTeamCityAgentCollector(server)
+	},
+	"builds": func(server *Server) prometheus.Collector {
+		return NewTeamCityBuildsCollector(server)
+	},
+	"cloud": func(server *Server) prometheus.Collector {
+		return NewTeamCityCloudCollector(server)
+	},
+	"investigations": func(server *Server) prometheus.Collector {
+		return NewTeamCityInvestigationsCollector(server)
+	},
+	"pools": func(server *Server) prometheus.Collector {
+		return NewTeamCityAgentPoolsCollector(server)
+	},
+	"problems": func(server *Server) prometheus.Collector {
+		return NewTeamCityProblemsCollector(server)
+	},
+	"projects": func(server *Server) prometheus.Collector {
+		return NewTeamCityProjectsCollector(server)
+	},
+	"queue": func(server *Server) prometheus.Collector {
+		return NewTeamCityQueueCollector(server)
+	},
+	"server": func(server *Server) prometheus.Collector {
+		return NewTeamCityServerCollector(server)
+	},
+	"statistics": func(server *Server) prometheus.Collector {
+		return NewTeamCityStatisticsCollector(server)
+	},
+	"templates": func(server *Server) prometheus.Collector {
+		return NewTeamCityTemplatesCollector(server)
+	},
+	"users": func(server *Server) prometheus.Collector {
+		return NewTeamCityUsersCollector(server)
+	},
+	"vcs": func(server *Server) prometheus.Collector {
+		return NewTeamCityVcsRootsCollector(server)
+	},
+}
+
+// EnabledCollectors parses a comma-separated allowlist of collector names, an empty list enables every collector.
+func EnabledCollectors(value string) ([]string, error) {
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := collectorFactories[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		for name := range collectorFactories {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnabledCollectors(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"queue, builds,", "builds,queue", false},
+		{"projects", "projects", false},
+		{"builds,unknown", "", true},
+	}
+
+	for _, test := range tests {
+		names, err := EnabledCollectors(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("EnabledCollectors(%q) error = %v", test.value, err)
+			continue
+		}
+		if got := strings.Join(names, ","); got != test.want {
+			t.Errorf("EnabledCollectors(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestEnabledCollectorsDefaultsToAll(t *testing.T) {
+	names, err := EnabledCollectors(" ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(collectorFactories) {
+		t.Errorf("EnabledCollectors() = %v, want all %d collectors", names, len(collectorFactories))
+	}
+}
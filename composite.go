@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// PrioritizeCollectors orders collector names by a comma-separated priority list. Collectors missing from the list
+// keep their relative order after the listed ones, names in the list that are not enabled are ignored.
+func PrioritizeCollectors(names []string, priority string) []string {
+	ranks := map[string]int{}
+	for rank, name := range strings.Split(priority, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := ranks[name]; name != "" && !ok {
+			ranks[name] = rank
+		}
+	}
+
+	ordered := append([]string{}, names...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		rankI, okI := ranks[ordered[i]]
+		rankJ, okJ := ranks[ordered[j]]
+		if okI && okJ {
+			return rankI < rankJ
+		}
+		return okI && !okJ
+	})
+	return ordered
+}
+
+// CompositeCollector runs a set of collectors in priority order. With a deadline, collectors run one after the other
+// and those that are not expected to finish before the deadline are skipped, so that the high-priority collectors
+// still produce metrics when the scrape budget is tight. Without a deadline all collectors run concurrently.
+type CompositeCollector struct {
+	collectors []*CachedCollector
+	deadline   time.Duration
+
+	// How long each collector took the last time it ran, used to predict whether it fits before the deadline.
+	mutex     sync.Mutex
+	durations map[string]time.Duration
+
+	collectorSkipped *prometheus.Desc
+	scrapeDuration   *prometheus.Desc
+}
+
+func NewCompositeCollector(collectors []*CachedCollector, deadline time.Duration) *CompositeCollector {
+	constLabels := prometheus.Labels{}
+
+	return &CompositeCollector{
+		collectors: collectors,
+		deadline:   deadline,
+		durations:  map[string]time.Duration{},
+
+		collectorSkipped: prometheus.NewDesc(
+			"teamcity_exporter_collector_skipped",
+			"Whether a collector was skipped during the last scrape because of the collection deadline.",
+			[]string{"collector"},
+			constLabels,
+		),
+		scrapeDuration: prometheus.NewDesc(
+			"teamcity_exporter_scrape_duration_seconds",
+			"The duration of the last scrape across all collectors.",
+			[]string{},
+			constLabels,
+		),
+	}
+}
+
+func (composite *CompositeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range composite.collectors {
+		collector.Describe(ch)
+	}
+	ch <- composite.collectorSkipped
+	ch <- composite.scrapeDuration
+}
+
+func (composite *CompositeCollector) Collect(ch chan<- prometheus.Metric) {
+	// Set the scrape duration metric once every collector ran or was skipped.
+	start := time.Now()
+	defer func() {
+		ch <- prometheus.MustNewConstMetric(composite.scrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds())
+	}()
+
+	if composite.deadline <= 0 {
+		wg := sync.WaitGroup{}
+		for _, collector := range composite.collectors {
+			wg.Add(1)
+			go func(collector *CachedCollector) {
+				defer wg.Done()
+				composite.collect(collector, ch)
+			}(collector)
+		}
+		wg.Wait()
+		return
+	}
+
+	deadline := time.Now().Add(composite.deadline)
+	for _, collector := range composite.collectors {
+		composite.mutex.Lock()
+		expected := composite.durations[collector.name]
+		composite.mutex.Unlock()
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 || expected > remaining {
+			logrus.WithFields(logrus.Fields{
+				"collector": collector.name,
+				"expected":  expected,
+				"remaining": remaining,
+			}).Warn("skipping collector to meet the collection deadline")
+			ch <- prometheus.MustNewConstMetric(composite.collectorSkipped, prometheus.GaugeValue, 1, collector.name)
+			continue
+		}
+
+		composite.collect(collector, ch)
+	}
+}
+
+func (composite *CompositeCollector) collect(collector *CachedCollector, ch chan<- prometheus.Metric) {
+	start := time.Now()
+	collector.Collect(ch)
+
+	composite.mutex.Lock()
+	composite.durations[collector.name] = time.Since(start)
+	composite.mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(composite.collectorSkipped, prometheus.GaugeValue, 0, collector.name)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrioritizeCollectors(t *testing.T) {
+	names := []string{"agents", "builds", "projects", "queue"}
+
+	tests := []struct {
+		priority string
+		want     string
+	}{
+		{"", "agents,builds,projects,queue"},
+		{"queue, agents", "queue,agents,builds,projects"},
+		{"unknown,projects,queue,projects", "projects,queue,agents,builds"},
+	}
+
+	for _, test := range tests {
+		if got := strings.Join(PrioritizeCollectors(names, test.priority), ","); got != test.want {
+			t.Errorf("PrioritizeCollectors(%q) = %q, want %q", test.priority, got, test.want)
+		}
+	}
+}
+
+func TestCompositeCollectorDeadline(t *testing.T) {
+	agents, builds := newGatedCollector("teamcity_test_agents"), newGatedCollector("teamcity_test_builds")
+	close(agents.gate)
+	close(builds.gate)
+
+	composite := NewCompositeCollector([]*CachedCollector{
+		NewCachedCollector("test", "agents", agents, 0, 0),
+		NewCachedCollector("test", "builds", builds, 0, 0),
+	}, time.Second)
+	// The builds collector took longer than the deadline the last time it ran.
+	composite.durations["builds"] = time.Minute
+
+	expected := `
+# HELP teamcity_exporter_collector_skipped Whether a collector was skipped during the last scrape because of the collection deadline.
+# TYPE teamcity_exporter_collector_skipped gauge
+teamcity_exporter_collector_skipped{collector="agents"} 0
+teamcity_exporter_collector_skipped{collector="builds"} 1
+# HELP teamcity_test_agents The number of collections so far.
+# TYPE teamcity_test_agents gauge
+teamcity_test_agents 1
+`
+	err := testutil.CollectAndCompare(
+		composite,
+		strings.NewReader(expected),
+		"teamcity_exporter_collector_skipped",
+		"teamcity_test_agents",
+		"teamcity_test_builds",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
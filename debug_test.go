@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProfilingHandlers(t *testing.T) {
+	paths := []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/symbol"}
+
+	// The debug mux, like our metrics mux, only serves the profiling handlers once they are registered.
+	mux := NewDebugMux()
+	for _, path := range paths {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%s before registering: status = %d, want %d", path, recorder.Code, http.StatusNotFound)
+		}
+	}
+
+	RegisterProfilingHandlers(mux)
+	for _, path := range paths {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s after registering: status = %d, want %d", path, recorder.Code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+// Diagnostics describes what the exporter's credentials reach, so operators can sanity-check access and scope
+// before trusting the metrics.
+type Diagnostics struct {
+	Server     string   `json:"server,omitempty"`
+	Root       string   `json:"root"`
+	Collectors []string `json:"collectors"`
+	Projects   uint64   `json:"projects"`
+	BuildTypes uint64   `json:"build_types"`
+	Agents     uint64   `json:"agents"`
+}
+
+// DiagnosticsHandler reports the number of projects and build types reachable from the root project, and the number
+// of agents, as JSON. The server query parameter picks the server to report on when there are several.
+type DiagnosticsHandler struct {
+	servers    []*Server
+	collectors []string
+}
+
+func NewDiagnosticsHandler(servers []*Server, collectors []string) *DiagnosticsHandler {
+	return &DiagnosticsHandler{
+		servers:    servers,
+		collectors: collectors,
+	}
+}
+
+func (handler *DiagnosticsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server, err := SelectServer(handler.servers, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	diagnostics := &Diagnostics{
+		Server:     server.Name,
+		Root:       viper.GetString("root.project.id"),
+		Collectors: handler.collectors,
+	}
+
+	ctx := r.Context()
+	err = countProjects(ctx, server, diagnostics.Root, diagnostics)
+	if err == nil {
+		err = countAgents(ctx, server, diagnostics)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("diagnostics failed")
+		http.Error(w, fmt.Sprintf("diagnostics failed: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(diagnostics)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// countProjects counts a project and its build types, then walks its subprojects one at a time.
+func countProjects(ctx context.Context, server *Server, identifier string, diagnostics *Diagnostics) error {
+	p, err := server.API.GetProject(ctx, identifier)
+	if err != nil {
+		return fmt.Errorf("project %s is inaccessible: %w", identifier, err)
+	}
+
+	diagnostics.Projects++
+	diagnostics.BuildTypes += uint64(p.BuildTypes.Count)
+
+	for _, subproject := range p.ChildProjects.Items {
+		err := countProjects(ctx, server, subproject.ID, diagnostics)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countAgents(ctx context.Context, server *Server, diagnostics *Diagnostics) error {
+	locator := fmt.Sprintf("count:%d,connected:any,authorized:any", viper.GetUint("page.count"))
+	agents, err := server.API.ListAgents(ctx, locator, "id")
+	if err != nil {
+		return err
+	}
+
+	diagnostics.Agents = uint64(len(agents))
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiagnosticsHandler(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root"})
+	server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/agents": fixture(t, "agents.json")}))
+	projects := filterTestTree()
+	projects["Team"].BuildTypes.Count = 2
+	projects["Team_Legacy_Keep"].BuildTypes.Count = 1
+	server.API.Projects = projects
+
+	recorder := httptest.NewRecorder()
+	NewDiagnosticsHandler([]*Server{server}, []string{"agents", "builds"}).ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/config", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+
+	var diagnostics Diagnostics
+	if err := json.NewDecoder(recorder.Body).Decode(&diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics.Projects != 6 || diagnostics.BuildTypes != 3 || diagnostics.Agents != 3 {
+		t.Errorf("diagnostics = %+v, want 6 projects, 3 build types, and 3 agents", diagnostics)
+	}
+	if diagnostics.Server != "test" || diagnostics.Root != "_Root" || len(diagnostics.Collectors) != 2 {
+		t.Errorf("diagnostics = %+v, want the test server's root and collectors", diagnostics)
+	}
+}
+
+func TestDiagnosticsHandlerInaccessibleProject(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "Missing"})
+	server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/agents": fixture(t, "agents.json")}))
+
+	recorder := httptest.NewRecorder()
+	NewDiagnosticsHandler([]*Server{server}, nil).ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/config", nil))
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadGateway)
+	}
+}
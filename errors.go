@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var scrapeErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "teamcity_exporter_scrape_errors_total",
+		Help: "The total number of errors a collector ran into while talking to TeamCity.",
+	},
+	[]string{"server", "collector"},
+)
+
+var scrapeSuccess = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "teamcity_exporter_last_scrape_success",
+		Help: "Whether the last collection of a collector finished without errors.",
+	},
+	[]string{"server", "collector"},
+)
+
+var partialScrape = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "teamcity_exporter_partial_scrape",
+		Help: "Whether the last collection of a collector only returned part of its metrics.",
+	},
+	[]string{"server", "collector"},
+)
+
+// scrapeErrorCounts mirrors scrapeErrors per server and collector, so that a collection can tell whether it ran into
+// errors without reading the counter back.
+var scrapeErrorCounts sync.Map
+
+// scrapeErrorKey identifies the collector of a server in scrapeErrorCounts.
+type scrapeErrorKey struct {
+	server    string
+	collector string
+}
+
+// countScrapeError records an error a collector of a server ran into, the error itself is logged by the caller. The
+// single unnamed server of a configuration without a servers list has an empty server name.
+func countScrapeError(server string, collector string) {
+	scrapeErrors.WithLabelValues(server, collector).Inc()
+	count, _ := scrapeErrorCounts.LoadOrStore(scrapeErrorKey{server, collector}, new(uint64))
+	atomic.AddUint64(count.(*uint64), 1)
+}
+
+func scrapeErrorCount(server string, collector string) uint64 {
+	count, ok := scrapeErrorCounts.Load(scrapeErrorKey{server, collector})
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(count.(*uint64))
+}
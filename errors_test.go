@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestScrapeErrorsCounted(t *testing.T) {
+	failing := true
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count": 0}`))
+	}))
+	server.Name = "errors"
+	cached := NewCachedCollector(server.Name, "agents", NewTeamCityAgentCollector(server), 0, 0)
+	errors := scrapeErrors.WithLabelValues(server.Name, "agents")
+	success := scrapeSuccess.WithLabelValues(server.Name, "agents")
+	before := testutil.ToFloat64(errors)
+
+	testutil.CollectAndCount(cached)
+	if got := testutil.ToFloat64(errors) - before; got != 1 {
+		t.Errorf("teamcity_exporter_scrape_errors_total increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(success); got != 0 {
+		t.Errorf("teamcity_exporter_last_scrape_success = %v after a failed collection, want 0", got)
+	}
+
+	// The counter survives the next collection, which succeeds.
+	failing = false
+	testutil.CollectAndCount(cached)
+	if got := testutil.ToFloat64(errors) - before; got != 1 {
+		t.Errorf("teamcity_exporter_scrape_errors_total increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(success); got != 1 {
+		t.Errorf("teamcity_exporter_last_scrape_success = %v after a successful collection, want 1", got)
+	}
+}
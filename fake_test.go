@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/cvbarros/go-teamcity/teamcity"
+	viper "github.com/spf13/viper"
+)
+
+// fakeProjects serves a made-up project tree, keyed by project ID.
+type fakeProjects map[string]*teamcity.Project
+
+func (projects fakeProjects) GetByID(id string) (*teamcity.Project, error) {
+	project, ok := projects[id]
+	if !ok {
+		return nil, fmt.Errorf("project %s not found", id)
+	}
+	return project, nil
+}
+
+// projectTree returns a project tree made of the root project and the given projects, all direct children of it.
+func projectTree(children ...string) fakeProjects {
+	root := &teamcity.Project{ID: "_Root", Name: "<Root project>"}
+	projects := fakeProjects{"_Root": root}
+	for _, child := range children {
+		root.ChildProjects.Items = append(root.ChildProjects.Items, &teamcity.ProjectReference{ID: child, Name: child})
+		projects[child] = &teamcity.Project{ID: child, Name: child, ParentProjectID: "_Root"}
+	}
+	return projects
+}
+
+// newTestServer returns a server whose REST API requests are answered by handler, and whose projects are the root
+// project alone.
+func newTestServer(t *testing.T, handler http.Handler) *Server {
+	t.Helper()
+	fake := httptest.NewServer(handler)
+	t.Cleanup(fake.Close)
+
+	return &Server{
+		Name:      "test",
+		Addr:      fake.URL,
+		API:       tcapi.NewClient(fake.URL, fake.Client(), projectTree()),
+		Semaphore: NewSemaphore(1),
+	}
+}
+
+// jsonRoutes answers requests with the JSON response of their path, and 404 for any other path. A route of the form
+// "path?text" only answers the requests to path whose unescaped query contains text, and takes precedence over the
+// route of the path alone.
+func jsonRoutes(routes map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, _ := url.QueryUnescape(r.URL.RawQuery)
+		body, ok := "", false
+		for route, response := range routes {
+			path, text, found := strings.Cut(route, "?")
+			if path == r.URL.Path && found && strings.Contains(query, text) {
+				body, ok = response, true
+				break
+			}
+		}
+		if !ok {
+			body, ok = routes[r.URL.Path]
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}
+}
+
+// fixture returns the content of a file in the testdata directory.
+func fixture(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// setConfig sets the given configuration keys for the duration of the test, the defaults of main are not set in tests.
+func setConfig(t *testing.T, config map[string]interface{}) {
+	t.Helper()
+	for key, value := range config {
+		viper.Set(key, value)
+	}
+	t.Cleanup(viper.Reset)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/spf13/viper"
+)
+
+// ProjectFilter decides which projects the project tree walks collect metrics for, based on regular expressions
+// matched against project IDs.
+type ProjectFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// NewProjectFilter compiles the comma-separated include and exclude patterns of a project filter.
+func NewProjectFilter(include string, exclude string) (*ProjectFilter, error) {
+	filter := &ProjectFilter{}
+
+	var err error
+	filter.include, err = compilePatterns(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project include pattern: %w", err)
+	}
+	filter.exclude, err = compilePatterns(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project exclude pattern: %w", err)
+	}
+
+	return filter, nil
+}
+
+func compilePatterns(value string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, identifier string) bool {
+	for _, re := range patterns {
+		if re.MatchString(identifier) {
+			return true
+		}
+	}
+	return false
+}
+
+// Root returns whether the root project is included, which it is unless there are include patterns to opt in with.
+func (filter *ProjectFilter) Root() bool {
+	return filter == nil || len(filter.include) == 0
+}
+
+// Included returns whether the metrics of a project are collected given whether its parent's were. A project matching
+// an include pattern is included, even when it matches an exclude pattern as well. Otherwise a project matching an
+// exclude pattern is excluded, and any other project follows its parent.
+func (filter *ProjectFilter) Included(identifier string, parent bool) bool {
+	if filter == nil {
+		return true
+	}
+	if matchAny(filter.include, identifier) {
+		return true
+	}
+	if matchAny(filter.exclude, identifier) {
+		return false
+	}
+	return parent
+}
+
+// Walk returns whether the subtree of a project has to be walked at all. Excluded subtrees are pruned, unless there
+// are include patterns a descendant could match.
+func (filter *ProjectFilter) Walk(identifier string, parent bool) bool {
+	return filter.Included(identifier, parent) || (filter != nil && len(filter.include) > 0)
+}
+
+// Empty returns whether the filter has no patterns at all and includes every project.
+func (filter *ProjectFilter) Empty() bool {
+	return filter == nil || len(filter.include) == 0 && len(filter.exclude) == 0
+}
+
+// IncludedProjects walks the project tree from the root project and returns the IDs of the projects the filter
+// includes, for the collectors listing items across the whole tree in one go and dropping those of excluded projects
+// afterwards. An empty filter includes every project without walking the tree, and returns nil.
+func (filter *ProjectFilter) IncludedProjects(ctx context.Context, api *tcapi.Client, root string) (map[string]bool, error) {
+	if filter.Empty() {
+		return nil, nil
+	}
+
+	included := map[string]bool{}
+	var walk func(identifier string, parent bool) error
+	walk = func(identifier string, parent bool) error {
+		if !filter.Walk(identifier, parent) {
+			return nil
+		}
+		p, err := api.GetProject(ctx, identifier)
+		if err != nil {
+			return err
+		}
+
+		include := filter.Included(identifier, parent)
+		included[identifier] = include
+		for _, subproject := range p.ChildProjects.Items {
+			err := walk(subproject.ID, include)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return included, walk(root, filter.Root())
+}
+
+// ConfiguredProjectFilter compiles the project filter of the exporter's configuration.
+func ConfiguredProjectFilter() (*ProjectFilter, error) {
+	return NewProjectFilter(viper.GetString("project.include"), viper.GetString("project.exclude"))
+}
+
+// BranchFilter decides which branches the builds collector collects builds of, either through the branch dimension of
+// the builds locator or a regular expression matched against branch names.
+type BranchFilter struct {
+	locator string
+	re      *regexp.Regexp
+}
+
+// NewBranchFilter parses a branch filter. "default:any", the default, collects builds of every branch and
+// "default:true" those of the default branch alone, anything else is a regular expression builds of every branch are
+// matched against by their branch name.
+func NewBranchFilter(value string) (*BranchFilter, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "", "default:any":
+		return &BranchFilter{locator: "default:any"}, nil
+	case "default:true":
+		return &BranchFilter{locator: "default:true"}, nil
+	}
+
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid branch pattern: %w", err)
+	}
+	return &BranchFilter{locator: "default:any", re: re}, nil
+}
+
+// Locator returns the value of the branch dimension of the builds locator.
+func (filter *BranchFilter) Locator() string {
+	if filter == nil {
+		return "default:any"
+	}
+	return filter.locator
+}
+
+// Match returns whether builds of a branch are collected. Builds of build types without VCS branches have an empty
+// branch name.
+func (filter *BranchFilter) Match(branch string) bool {
+	return filter == nil || filter.re == nil || filter.re.MatchString(branch)
+}
+
+// ConfiguredBranchFilter returns the branch filter of the exporter's configuration. Without a branch filter, default
+// branch only still limits the builds to the default branch.
+func ConfiguredBranchFilter() (*BranchFilter, error) {
+	value := viper.GetString("builds.branch")
+	if strings.TrimSpace(value) == "" && viper.GetBool("builds.default_branch_only") {
+		value = "default:true"
+	}
+	return NewBranchFilter(value)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/cvbarros/go-teamcity/teamcity"
+)
+
+// filterTestTree returns the project tree the filter tests walk:
+//
+//	_Root
+//	├── Team
+//	│   ├── Team_Sub
+//	│   └── Team_Legacy
+//	│       └── Team_Legacy_Keep
+//	└── Other
+func filterTestTree() fakeProjects {
+	projects := projectTree("Team", "Other")
+	children := map[string][]string{
+		"Team":        {"Team_Sub", "Team_Legacy"},
+		"Team_Legacy": {"Team_Legacy_Keep"},
+	}
+	for _, parent := range []string{"Team", "Team_Legacy"} {
+		for _, child := range children[parent] {
+			projects[parent].ChildProjects.Items = append(projects[parent].ChildProjects.Items, &teamcity.ProjectReference{ID: child})
+			projects[child] = &teamcity.Project{ID: child, Name: child, ParentProjectID: parent}
+		}
+	}
+	return projects
+}
+
+func TestProjectFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		want    string
+	}{
+		{"include only", "^Team$", "", "Team,Team_Legacy,Team_Legacy_Keep,Team_Sub"},
+		{"exclude only", "", "Legacy", "Other,Team,Team_Sub,_Root"},
+		{"include below exclude", "_Keep$", "Legacy", "Team_Legacy_Keep"},
+		{"exclude below include", "^Team$", "Legacy", "Team,Team_Sub"},
+		{"include over exclude", "^Team", "Legacy", "Team,Team_Legacy,Team_Legacy_Keep,Team_Sub"},
+	}
+
+	api := tcapi.NewClient("", nil, filterTestTree())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := NewProjectFilter(test.include, test.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			projects, err := filter.IncludedProjects(context.Background(), api, "_Root")
+			if err != nil {
+				t.Fatal(err)
+			}
+			included := []string{}
+			for project, include := range projects {
+				if include {
+					included = append(included, project)
+				}
+			}
+			sort.Strings(included)
+
+			if got := strings.Join(included, ","); got != test.want {
+				t.Errorf("included projects = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProjectFilterPrunesExcludedSubtrees(t *testing.T) {
+	filter, err := NewProjectFilter("", "Legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projects, err := filter.IncludedProjects(context.Background(), tcapi.NewClient("", nil, filterTestTree()), "_Root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, walked := projects["Team_Legacy_Keep"]; walked {
+		t.Error("the subtree of an excluded project was walked without include patterns")
+	}
+}
+
+func TestProjectFilterEmpty(t *testing.T) {
+	filter, err := NewProjectFilter(" , ", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Empty() || !filter.Root() {
+		t.Error("a filter without patterns does not include every project")
+	}
+
+	projects, err := filter.IncludedProjects(context.Background(), tcapi.NewClient("", nil, filterTestTree()), "_Root")
+	if projects != nil || err != nil {
+		t.Errorf("IncludedProjects() = %v, %v, want the tree left unwalked", projects, err)
+	}
+}
+
+func TestProjectFilterInvalidPattern(t *testing.T) {
+	for _, patterns := range [][2]string{{"Team,(", ""}, {"", "["}} {
+		if _, err := NewProjectFilter(patterns[0], patterns[1]); err == nil {
+			t.Errorf("NewProjectFilter(%q, %q) did not fail", patterns[0], patterns[1])
+		}
+	}
+}
+
+func TestBranchFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		locator string
+		match   map[string]bool
+	}{
+		{"", "default:any", map[string]bool{"main": true, "feature/x": true}},
+		{"default:true", "default:true", map[string]bool{"main": true, "feature/x": true}},
+		{"^(main|release/.*)$", "default:any", map[string]bool{"main": true, "release/1.0": true, "feature/x": false, "": false}},
+	}
+
+	for _, test := range tests {
+		filter, err := NewBranchFilter(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filter.Locator(); got != test.locator {
+			t.Errorf("NewBranchFilter(%q).Locator() = %q, want %q", test.value, got, test.locator)
+		}
+		for branch, want := range test.match {
+			if got := filter.Match(branch); got != want {
+				t.Errorf("NewBranchFilter(%q).Match(%q) = %v, want %v", test.value, branch, got, want)
+			}
+		}
+	}
+
+	if _, err := NewBranchFilter("release/(.*"); err == nil {
+		t.Error("NewBranchFilter() did not fail on an invalid pattern")
+	}
+}
+
+func TestConfiguredBranchFilterDefaultBranchOnly(t *testing.T) {
+	setConfig(t, map[string]interface{}{"builds.default_branch_only": true})
+
+	filter, err := ConfiguredBranchFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filter.Locator(); got != "default:true" {
+		t.Errorf("Locator() = %q, want default:true", got)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+// ReadinessHandler reports the exporter as ready once every configured server passes its readiness check.
+type ReadinessHandler struct {
+	servers []*Server
+}
+
+func NewReadinessHandler(servers []*Server) *ReadinessHandler {
+	return &ReadinessHandler{servers: servers}
+}
+
+func (handler *ReadinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := handler.check(r.Context())
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("readiness check failed")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not ready: %s\n", err)
+		return
+	}
+
+	fmt.Fprintln(w, "ready")
+}
+
+// check runs the readiness check of every server, the error of the first server failing it names that server.
+func (handler *ReadinessHandler) check(ctx context.Context) error {
+	for _, server := range handler.servers {
+		err := checkServer(ctx, server)
+		if err != nil && server.Name != "" {
+			return fmt.Errorf("server %s: %w", server.Name, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkServer verifies TeamCity is reachable with the configured credentials and, optionally, that the root project
+// is accessible. A token can authenticate yet lack access to the root project, in which case scrapes come back empty.
+func checkServer(ctx context.Context, server *Server) error {
+	url := fmt.Sprintf("%s/app/rest/server", server.Addr)
+
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := server.API.HTTPClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("TeamCity server is unreachable: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("TeamCity server responded with %s", response.Status)
+	}
+
+	if viper.GetBool("readyz.check_root") {
+		root := viper.GetString("root.project.id")
+		_, err := server.API.GetProject(ctx, root)
+		if err != nil {
+			return fmt.Errorf("root project %s is inaccessible: %w", root, err)
+		}
+	}
+
+	return nil
+}
+
+// HealthHandler is a cheap probe of TeamCity connectivity and authentication, bounded by its own short timeout so a
+// hung TeamCity fails the probe instead of hanging it.
+type HealthHandler struct {
+	readiness *ReadinessHandler
+	timeout   time.Duration
+}
+
+func NewHealthHandler(servers []*Server, timeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		readiness: NewReadinessHandler(servers),
+		timeout:   timeout,
+	}
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (handler *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), handler.timeout)
+	defer cancel()
+
+	err := handler.readiness.check(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("TeamCity did not respond within %s", handler.timeout)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := healthResponse{Status: "ok"}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("health check failed")
+		response = healthResponse{Status: "unavailable", Error: err.Error()}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
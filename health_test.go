@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/cvbarros/go-teamcity/teamcity"
+)
+
+func TestReadinessChecksRootProject(t *testing.T) {
+	setConfig(t, map[string]interface{}{"readyz.check_root": true, "root.project.id": "_Root"})
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/app/rest/projects/") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer fake.Close()
+
+	server := &Server{Name: "main", Addr: fake.URL, Token: "secret"}
+	projects, err := teamcity.NewClientWithAddress(server.AuthMethod(), fake.URL, fake.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.API = tcapi.NewClient(fake.URL, fake.Client(), projects.Projects)
+
+	recorder := httptest.NewRecorder()
+	NewReadinessHandler([]*Server{server}).ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "root project _Root is inaccessible") {
+		t.Errorf("body = %q, want it to name the inaccessible root project", body)
+	}
+}
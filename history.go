@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+)
+
+// BuildHistory keeps the builds of each project between collections, so that an incremental collection only fetches
+// the builds that started since, or were still running at, the previous one and merges them into the rest.
+type BuildHistory struct {
+	mutex    sync.Mutex
+	projects map[string]map[uint64]Build
+}
+
+func NewBuildHistory() *BuildHistory {
+	return &BuildHistory{projects: map[string]map[uint64]Build{}}
+}
+
+// Since returns the start date to fetch the builds of a project from, the start of its earliest unfinished build, or
+// of its latest build when all of them finished. It returns false when there is no history of the project yet and
+// every build has to be fetched.
+func (history *BuildHistory) Since(project string) (time.Time, bool) {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	var latest, unfinished time.Time
+	for _, build := range history.projects[project] {
+		if build.StartDate.After(latest) {
+			latest = build.StartDate.Time
+		}
+		if tcapi.ParseBuildState(build.State) != tcapi.BuildFinished && (unfinished.IsZero() || build.StartDate.Before(unfinished)) {
+			unfinished = build.StartDate.Time
+		}
+	}
+
+	since := latest
+	if !unfinished.IsZero() {
+		since = unfinished
+	}
+	if since.IsZero() {
+		return time.Time{}, false
+	}
+
+	// TeamCity dates have a resolution of a second, step back one so builds starting in the same second are not missed.
+	return since.Add(-time.Second), true
+}
+
+// Merge records the fetched builds of a project, replacing the builds fetched again and forgetting those queued before
+// the cutoff as well as all but the latest limit builds, and returns every build of the project with the latest first
+// like TeamCity lists them. A zero cutoff or limit does not forget any build on its account. Builds that were
+// unfinished are always fetched again, those missing from the fetched builds were canceled or deleted in the meantime
+// and are forgotten.
+func (history *BuildHistory) Merge(project string, builds []Build, cutoff time.Time, limit int) []Build {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	known := history.projects[project]
+	if known == nil {
+		known = map[uint64]Build{}
+		history.projects[project] = known
+	}
+	for id, build := range known {
+		if tcapi.ParseBuildState(build.State) != tcapi.BuildFinished {
+			delete(known, id)
+		}
+	}
+	for _, build := range builds {
+		known[build.ID] = build
+	}
+
+	merged := make([]Build, 0, len(known))
+	for id, build := range known {
+		if !cutoff.IsZero() && build.QueuedDate.Before(cutoff) {
+			delete(known, id)
+			continue
+		}
+		merged = append(merged, build)
+	}
+
+	// Build IDs increase over time, so the latest builds have the highest IDs.
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ID > merged[j].ID })
+	if limit > 0 && len(merged) > limit {
+		for _, build := range merged[limit:] {
+			delete(known, build.ID)
+		}
+		merged = merged[:limit]
+	}
+	return merged
+}
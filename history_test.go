@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func finishedBuild(id uint64, queued time.Time) Build {
+	return Build{ID: id, State: "finished", QueuedDate: TeamCityTime{Time: queued}}
+}
+
+func TestBuildHistoryMerge(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	history := NewBuildHistory()
+
+	merged := history.Merge("Project", []Build{finishedBuild(2, now), finishedBuild(1, now.Add(-time.Hour))}, time.Time{}, 0)
+	if len(merged) != 2 || merged[0].ID != 2 {
+		t.Fatalf("merged = %+v, want builds 2 and 1", merged)
+	}
+
+	merged = history.Merge("Project", []Build{finishedBuild(3, now)}, now.Add(-30*time.Minute), 0)
+	if len(merged) != 2 || merged[0].ID != 3 || merged[1].ID != 2 {
+		t.Errorf("merged = %+v, want build 1 queued before the cutoff forgotten", merged)
+	}
+}
+
+func TestBuildHistoryMergeLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	history := NewBuildHistory()
+
+	history.Merge("Project", []Build{finishedBuild(2, now), finishedBuild(1, now)}, time.Time{}, 2)
+	merged := history.Merge("Project", []Build{finishedBuild(3, now)}, time.Time{}, 2)
+	if len(merged) != 2 || merged[0].ID != 3 || merged[1].ID != 2 {
+		t.Errorf("merged = %+v, want the latest two builds without a cutoff", merged)
+	}
+
+	if len(history.projects["Project"]) != 2 {
+		t.Errorf("history keeps %d builds, want 2", len(history.projects["Project"]))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var apiRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "teamcity_exporter_api_requests_total",
+		Help: "The total number of requests made to the TeamCity REST API.",
+	},
+	[]string{"server", "endpoint", "code"},
+)
+
+var apiRetries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "teamcity_exporter_api_retries_total",
+		Help: "The total number of retried requests to the TeamCity REST API.",
+	},
+	[]string{"server", "endpoint"},
+)
+
+// retryCounter returns the request hook of a server's retryable HTTP client, it counts every attempt after the first
+// of a request.
+func retryCounter(server string) retryablehttp.RequestLogHook {
+	return func(_ retryablehttp.Logger, request *http.Request, attempt int) {
+		if attempt > 0 {
+			apiRetries.WithLabelValues(server, apiEndpoint(request.URL.Path)).Inc()
+		}
+	}
+}
+
+// InstrumentedTransport counts the requests made to a TeamCity server by endpoint and status code, requests that fail without
+// a response are counted with an "error" code.
+type InstrumentedTransport struct {
+	server    string
+	transport http.RoundTripper
+}
+
+func NewInstrumentedTransport(server string, transport http.RoundTripper) *InstrumentedTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &InstrumentedTransport{server: server, transport: transport}
+}
+
+func (instrumented *InstrumentedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := instrumented.transport.RoundTrip(request)
+
+	code := "error"
+	if err == nil {
+		code = fmt.Sprintf("%d", response.StatusCode)
+	}
+	apiRequests.WithLabelValues(instrumented.server, apiEndpoint(request.URL.Path), code).Inc()
+
+	return response, err
+}
+
+// apiEndpoint reduces a TeamCity request path to its endpoint, e.g. "/app/rest/projects/id:Foo" to "projects", so
+// that IDs in the path do not end up as label values.
+func apiEndpoint(path string) string {
+	for _, prefix := range []string{"/app/rest/", "/app/"} {
+		if i := strings.Index(path, prefix); i >= 0 {
+			return strings.SplitN(path[i+len(prefix):], "/", 2)[0]
+		}
+	}
+	return "other"
+}
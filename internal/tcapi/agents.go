@@ -0,0 +1,39 @@
+package tcapi
+
+type CompatibilityCount struct {
+	Count uint64 `json:"count"`
+}
+
+// AgentPoolReference names the agent pool an agent belongs to.
+type AgentPoolReference struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+type Agent struct {
+	ID                     uint64              `json:"id"`
+	Name                   string              `json:"name"`
+	Authorized             bool                `json:"authorized"`
+	Connected              bool                `json:"connected"`
+	Enabled                bool                `json:"enabled"`
+	CurrentBuild           Build               `json:"build"`
+	CompatibleBuildTypes   *CompatibilityCount `json:"compatibleBuildTypes,omitempty"`
+	IncompatibleBuildTypes *CompatibilityCount `json:"incompatibleBuildTypes,omitempty"`
+	Pool                   *AgentPoolReference `json:"pool,omitempty"`
+}
+
+// Busy reports whether the agent is currently running a build.
+func (agent Agent) Busy() bool {
+	return agent.CurrentBuild.ID != 0
+}
+
+// UnexpectedlyDisconnected reports whether the agent dropped its connection while still enabled, as opposed to being
+// disabled before disconnecting.
+func (agent Agent) UnexpectedlyDisconnected() bool {
+	return agent.Enabled && !agent.Connected
+}
+
+type AgentsResponse struct {
+	Page
+	Agents []Agent `json:"agent"`
+}
@@ -0,0 +1,30 @@
+package tcapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAgentUnexpectedlyDisconnected(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want bool
+	}{
+		{"enabled and connected", `{"id": 1, "enabled": true, "connected": true}`, false},
+		{"enabled but disconnected", `{"id": 2, "enabled": true, "connected": false}`, true},
+		{"disabled and disconnected", `{"id": 3, "enabled": false, "connected": false}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var agent Agent
+			if err := json.Unmarshal([]byte(test.json), &agent); err != nil {
+				t.Fatal(err)
+			}
+			if got := agent.UnexpectedlyDisconnected(); got != test.want {
+				t.Errorf("UnexpectedlyDisconnected() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
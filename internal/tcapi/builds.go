@@ -0,0 +1,219 @@
+package tcapi
+
+import (
+	"strconv"
+	"time"
+)
+
+type BuildStatus int
+type BuildState int
+
+// The numeric values are exported as metric values, new values are only ever appended to keep dashboards stable.
+const (
+	BuildStateUnknown BuildState = iota
+	BuildQueued
+	BuildFinished
+	BuildRunning
+	BuildDeleted
+	BuildInterrupted
+	BuildCanceled
+)
+
+const (
+	BuildStatusUnknown BuildStatus = iota
+	BuildSuccess
+	BuildFailure
+	BuildError
+)
+
+func ParseBuildState(s string) BuildState {
+	switch s {
+	case "queued":
+		return BuildQueued
+	case "finished":
+		return BuildFinished
+	case "running":
+		return BuildRunning
+	case "deleted":
+		return BuildDeleted
+	case "interrupted":
+		return BuildInterrupted
+	case "canceled", "cancelled":
+		return BuildCanceled
+	}
+	return BuildStateUnknown
+}
+
+func ParseBuildStatus(s string) BuildStatus {
+	switch s {
+	case "SUCCESS":
+		return BuildSuccess
+	case "FAILURE":
+		return BuildFailure
+	case "ERROR":
+		return BuildError
+	case "UNKNOWN":
+		return BuildStatusUnknown
+	}
+	return BuildStatusUnknown
+}
+
+// ProblemTypeExecutionTimeout is the problem occurrence type TeamCity reports when a build exceeds its execution timeout.
+const ProblemTypeExecutionTimeout = "TC_EXECUTION_TIMEOUT"
+
+type ProblemOccurrence struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Identity string `json:"identity,omitempty"`
+	Build    *Build `json:"build,omitempty"`
+}
+
+type ProblemOccurrences struct {
+	Count              uint64              `json:"count"`
+	ProblemOccurrences []ProblemOccurrence `json:"problemOccurrence"`
+}
+
+type TestOccurrences struct {
+	Count   uint64 `json:"count"`
+	Passed  uint64 `json:"passed"`
+	Failed  uint64 `json:"failed"`
+	Ignored uint64 `json:"ignored"`
+}
+
+type User struct {
+	ID        uint64 `json:"id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Name      string `json:"name,omitempty"`
+	LastLogin Time   `json:"lastLogin,omitempty"`
+}
+
+type Triggered struct {
+	Type string `json:"type,omitempty"`
+	User *User  `json:"user,omitempty"`
+}
+
+type Artifacts struct {
+	Count uint64 `json:"count"`
+}
+
+type Dependencies struct {
+	Count uint64 `json:"count"`
+}
+
+type CanceledInfo struct {
+	User      *User `json:"user,omitempty"`
+	Timestamp Time  `json:"timestamp,omitempty"`
+}
+
+type Comment struct {
+	Text string `json:"text"`
+}
+
+type Build struct {
+	ID                 uint64             `json:"id"`
+	BuildTypeID        string             `json:"buildTypeId"`
+	Status             string             `json:"status"`
+	State              string             `json:"state"`
+	QueuedDate         Time               `json:"queuedDate,omitempty"`
+	StartDate          Time               `json:"startDate,omitempty"`
+	FinishDate         Time               `json:"finishDate,omitempty"`
+	ProblemOccurrences ProblemOccurrences `json:"problemOccurrences,omitempty"`
+	TestOccurrences    *TestOccurrences   `json:"testOccurrences,omitempty"`
+	Triggered          Triggered          `json:"triggered,omitempty"`
+	Comment            Comment            `json:"comment,omitempty"`
+	Number             string             `json:"number,omitempty"`
+	BranchName         string             `json:"branchName,omitempty"`
+	DefaultBranch      bool               `json:"defaultBranch,omitempty"`
+	BuildType          BuildType          `json:"buildType,omitempty"`
+	Agent              *Agent             `json:"agent,omitempty"`
+	Dependencies       *Dependencies      `json:"snapshot-dependencies,omitempty"`
+	Composite          bool               `json:"composite,omitempty"`
+	Personal           bool               `json:"personal,omitempty"`
+	CanceledInfo       *CanceledInfo      `json:"canceledInfo,omitempty"`
+	Artifacts          *Artifacts         `json:"artifacts,omitempty"`
+	Statistics         *Properties        `json:"statistics,omitempty"`
+	WebURL             string             `json:"webUrl,omitempty"`
+}
+
+// Statistic returns the value of one of a build's statistics, reporting false when the statistics were not fetched or
+// the build has no numeric value for it.
+func (build Build) Statistic(name string) (float64, bool) {
+	if build.Statistics == nil {
+		return 0, false
+	}
+	for _, property := range build.Statistics.Properties {
+		if property.Name != name {
+			continue
+		}
+		value, err := strconv.ParseFloat(property.Value, 64)
+		return value, err == nil
+	}
+	return 0, false
+}
+
+// ArtifactsSize returns the total size in bytes of a build's artifacts from its statistics, reporting false when the
+// statistics were not fetched or the build published no artifacts.
+func (build Build) ArtifactsSize() (float64, bool) {
+	return build.Statistic("ArtifactsSize")
+}
+
+// Duration returns how long a finished build ran, it is zero for builds that have not both started and finished.
+func (build Build) Duration() time.Duration {
+	if ParseBuildState(build.State) != BuildFinished || build.StartDate.IsZero() || build.FinishDate.IsZero() {
+		return 0
+	}
+	return build.FinishDate.Sub(build.StartDate.Time)
+}
+
+// Elapsed returns how long a build ran, or has been running for at the given time when it has not finished yet. It is
+// zero for builds that have not started.
+func (build Build) Elapsed(now time.Time) time.Duration {
+	if ParseBuildState(build.State) == BuildRunning && !build.StartDate.IsZero() {
+		return now.Sub(build.StartDate.Time)
+	}
+	return build.Duration()
+}
+
+// QueueWait returns how long the build waited in the queue before starting, it is zero when either date is missing.
+func (build Build) QueueWait() time.Duration {
+	if build.QueuedDate.IsZero() || build.StartDate.IsZero() {
+		return 0
+	}
+	return build.StartDate.Sub(build.QueuedDate.Time)
+}
+
+// Canceled reports whether the build was canceled, TeamCity reports the UNKNOWN status for canceled builds.
+func (build Build) Canceled() bool {
+	return build.CanceledInfo != nil || ParseBuildState(build.State) == BuildCanceled
+}
+
+// TimedOut reports whether the build failed because it exceeded its execution timeout.
+func (build Build) TimedOut() bool {
+	if ParseBuildStatus(build.Status) != BuildFailure {
+		return false
+	}
+
+	for _, problem := range build.ProblemOccurrences.ProblemOccurrences {
+		if problem.Type == ProblemTypeExecutionTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+// TriggerType returns the kind of trigger that started the build, e.g. vcs, schedule, or user. Builds triggered by a
+// finishing dependency are reported as dependency rather than TeamCity's buildType.
+func (build Build) TriggerType() string {
+	switch build.Triggered.Type {
+	case "":
+		return "unknown"
+	case "buildType":
+		return "dependency"
+	}
+	return build.Triggered.Type
+}
+
+type BuildResponse struct {
+	Page
+	Builds []Build `json:"build"`
+}
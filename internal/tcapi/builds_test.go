@@ -0,0 +1,73 @@
+package tcapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBuildElapsed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		json string
+		want time.Duration
+	}{
+		{"finished", `{"state": "finished", "startDate": "20240101T110000+0000", "finishDate": "20240101T111000+0000"}`, 10 * time.Minute},
+		{"running", `{"state": "running", "startDate": "20240101T115800+0000"}`, 2 * time.Minute},
+		{"queued", `{"state": "queued", "startDate": "", "finishDate": null}`, 0},
+		{"finished without start", `{"state": "finished", "finishDate": "20240101T111000+0000"}`, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var build Build
+			if err := json.Unmarshal([]byte(test.json), &build); err != nil {
+				t.Fatal(err)
+			}
+			if got := build.Elapsed(now); got != test.want {
+				t.Errorf("Elapsed() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseBuildState(t *testing.T) {
+	// The numeric values are exported as metric values and must not change.
+	tests := map[string]BuildState{
+		"":            0,
+		"queued":      1,
+		"finished":    2,
+		"running":     3,
+		"deleted":     4,
+		"interrupted": 5,
+		"canceled":    6,
+		"cancelled":   6,
+		"paused":      0,
+	}
+
+	for s, want := range tests {
+		if got := ParseBuildState(s); got != want {
+			t.Errorf("ParseBuildState(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestParseBuildStatus(t *testing.T) {
+	// The numeric values are exported as metric values and must not change.
+	tests := map[string]BuildStatus{
+		"":        0,
+		"SUCCESS": 1,
+		"FAILURE": 2,
+		"ERROR":   3,
+		"UNKNOWN": 0,
+		"success": 0,
+	}
+
+	for s, want := range tests {
+		if got := ParseBuildStatus(s); got != want {
+			t.Errorf("ParseBuildStatus(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
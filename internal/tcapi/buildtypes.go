@@ -0,0 +1,62 @@
+package tcapi
+
+// TriggerTypeVCS is the trigger type TeamCity uses for triggers that start builds on VCS changes.
+const TriggerTypeVCS = "vcsTrigger"
+
+type Trigger struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+}
+
+type Triggers struct {
+	Count    uint64    `json:"count"`
+	Triggers []Trigger `json:"trigger"`
+}
+
+// BuildConfigurationTypeDeployment is the value of the buildConfigurationType setting of deployment build types.
+const BuildConfigurationTypeDeployment = "DEPLOYMENT"
+
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Properties struct {
+	Count      uint64     `json:"count"`
+	Properties []Property `json:"property"`
+}
+
+// Get returns the value of the named property, or an empty string when it is not set.
+func (properties Properties) Get(name string) string {
+	for _, property := range properties.Properties {
+		if property.Name == name {
+			return property.Value
+		}
+	}
+	return ""
+}
+
+type BuildType struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	ProjectID  string     `json:"projectId,omitempty"`
+	Paused     bool       `json:"paused,omitempty"`
+	Parameters Properties `json:"parameters,omitempty"`
+	Settings   Properties `json:"settings,omitempty"`
+	Triggers   Triggers   `json:"triggers,omitempty"`
+}
+
+// IsDeployment reports whether the build type is configured as a deployment.
+func (buildType BuildType) IsDeployment() bool {
+	return buildType.Settings.Get("buildConfigurationType") == BuildConfigurationTypeDeployment
+}
+
+// HasVCSTrigger reports whether the build type is started automatically on VCS changes.
+func (buildType BuildType) HasVCSTrigger() bool {
+	for _, trigger := range buildType.Triggers.Triggers {
+		if trigger.Type == TriggerTypeVCS {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package tcapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildTypeHasVCSTrigger(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want bool
+	}{
+		{"vcs trigger", `{"id": "A", "triggers": {"count": 2, "trigger": [{"type": "schedulingTrigger"}, {"type": "vcsTrigger"}]}}`, true},
+		{"schedule only", `{"id": "B", "triggers": {"count": 1, "trigger": [{"type": "schedulingTrigger"}]}}`, false},
+		{"no triggers", `{"id": "C", "triggers": {"count": 0}}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buildType BuildType
+			if err := json.Unmarshal([]byte(test.json), &buildType); err != nil {
+				t.Fatal(err)
+			}
+			if got := buildType.HasVCSTrigger(); got != test.want {
+				t.Errorf("HasVCSTrigger() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildTypeParameters(t *testing.T) {
+	var buildType BuildType
+	err := json.Unmarshal([]byte(`{"id": "A", "parameters": {"count": 3, "property": [
+		{"name": "env.TARGET", "value": "production"},
+		{"name": "system.debug", "value": "false"},
+		{"name": "teamcity.ui.settings.readOnly", "value": "true"}
+	]}}`), &buildType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buildType.Parameters.Count != 3 {
+		t.Errorf("parameters count = %d, want 3", buildType.Parameters.Count)
+	}
+	if got := buildType.Parameters.Get("env.TARGET"); got != "production" {
+		t.Errorf("Get(env.TARGET) = %q, want production", got)
+	}
+	if got := buildType.Parameters.Get("env.MISSING"); got != "" {
+		t.Errorf("Get(env.MISSING) = %q, want an empty value", got)
+	}
+}
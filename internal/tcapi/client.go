@@ -0,0 +1,213 @@
+// Package tcapi requests the TeamCity REST API on behalf of the exporter's collectors. It holds the types the
+// collectors share and handles pagination, JSON decoding, and error wrapping, while authentication is left to the
+// HTTP client it is given.
+package tcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/cvbarros/go-teamcity/teamcity"
+)
+
+// ErrPageLimit is returned along with the items gathered so far when a listing has more pages than it may request.
+var ErrPageLimit = errors.New("reached the page limit")
+
+// StatusError is returned for a response with an error status code, e.g. when the credentials lack the permission to
+// read a resource.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("requesting %s: TeamCity responded with %s", err.Path, err.Status)
+}
+
+// ProjectService fetches TeamCity projects by ID. The go-teamcity client's project service implements it, and a fake
+// can stand in for it to walk a made-up project tree.
+type ProjectService interface {
+	GetByID(id string) (*teamcity.Project, error)
+}
+
+// Client requests the REST API of a single TeamCity server.
+type Client struct {
+	// The address of the TeamCity server, without the REST API path.
+	Addr string
+	// The HTTP client authenticating the requests against the server.
+	HTTPClient *http.Client
+	// The service the projects are fetched with.
+	Projects ProjectService
+}
+
+func NewClient(addr string, httpClient *http.Client, projects ProjectService) *Client {
+	return &Client{Addr: addr, HTTPClient: httpClient, Projects: projects}
+}
+
+// GetProject fetches a project by ID. The project service does not take a context, so the request is left to finish
+// in the background once ctx is done.
+func (client *Client) GetProject(ctx context.Context, identifier string) (*teamcity.Project, error) {
+	type result struct {
+		project *teamcity.Project
+		err     error
+	}
+
+	results := make(chan result, 1)
+	go func() {
+		project, err := client.Projects.GetByID(identifier)
+		results <- result{project, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.project, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("fetching project %s: %w", identifier, ctx.Err())
+	}
+}
+
+// ListBuilds lists the builds matching a build locator with the given build fields. At most maxPages pages are
+// requested, zero meaning no limit, the builds of those pages are returned along with ErrPageLimit when more remain.
+func (client *Client) ListBuilds(ctx context.Context, locator string, fields string, maxPages int) ([]Build, error) {
+	address := fmt.Sprintf(
+		"%s/app/rest/builds?locator=%s&fields=count,nextHref,build(%s)",
+		client.Addr,
+		url.QueryEscape(locator),
+		fields,
+	)
+
+	builds := []Build{}
+	err := GetPageLimit(ctx, client.HTTPClient, address, maxPages, func(page BuildResponse) {
+		builds = append(builds, page.Builds...)
+	})
+	return builds, err
+}
+
+// ListAgents lists the agents matching an agent locator with the given agent fields.
+func (client *Client) ListAgents(ctx context.Context, locator string, fields string) ([]Agent, error) {
+	address := fmt.Sprintf(
+		"%s/app/rest/agents?locator=%s&fields=count,nextHref,agent(%s)",
+		client.Addr,
+		url.QueryEscape(locator),
+		fields,
+	)
+
+	agents := []Agent{}
+	err := GetPages(ctx, client.HTTPClient, address, func(page AgentsResponse) {
+		agents = append(agents, page.Agents...)
+	})
+	return agents, err
+}
+
+// GetJSON requests the given TeamCity REST API URL and decodes the JSON response into value. A missing resource
+// leaves value untouched and is not reported as an error, other error responses are reported as a StatusError.
+// Errors name the requested endpoint.
+func GetJSON(ctx context.Context, client *http.Client, address string, value interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", address, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("requesting %s: %w", request.URL.Path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		return &StatusError{Path: request.URL.Path, StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", request.URL.Path, err)
+	}
+
+	err = json.Unmarshal(body, value)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", request.URL.Path, err)
+	}
+	return nil
+}
+
+// Page holds the paging fields shared by the paged TeamCity REST API responses.
+type Page struct {
+	Count    uint64 `json:"count"`
+	HRef     string `json:"href,omitempty"`
+	NextHRef string `json:"nextHref,omitempty"`
+	PrevHRef string `json:"prevHref,omitempty"`
+}
+
+func (page Page) NextPage() string {
+	return page.NextHRef
+}
+
+// Paged is a response of the TeamCity REST API that may link to a next page.
+type Paged interface {
+	NextPage() string
+}
+
+// GetPages requests the given TeamCity REST API URL and follows the next page links of the responses, handing every
+// decoded page to visit in turn.
+func GetPages[T Paged](ctx context.Context, client *http.Client, address string, visit func(page T)) error {
+	return GetPageLimit(ctx, client, address, 0, visit)
+}
+
+// GetPageLimit follows the next page links like GetPages, but stops with ErrPageLimit once maxPages pages were
+// visited and more remain. A zero maxPages does not limit the number of pages.
+func GetPageLimit[T Paged](ctx context.Context, client *http.Client, address string, maxPages int, visit func(page T)) error {
+	for pages := 0; address != ""; pages++ {
+		if maxPages > 0 && pages >= maxPages {
+			return ErrPageLimit
+		}
+
+		var page T
+		err := GetJSON(ctx, client, address, &page)
+		if err != nil {
+			return err
+		}
+		visit(page)
+
+		address, err = NextPageURL(address, page.NextPage())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextPageURL resolves the nextHref link of a paged TeamCity REST API response against the current page's URL. The
+// fields selector of the current page is carried over when the link omits it. An empty link means there is no next
+// page.
+func NextPageURL(current string, href string) (string, error) {
+	if href == "" {
+		return "", nil
+	}
+
+	previous, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	reference, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	next := previous.ResolveReference(reference)
+
+	query := next.Query()
+	if query.Get("fields") == "" && previous.Query().Get("fields") != "" {
+		query.Set("fields", previous.Query().Get("fields"))
+		next.RawQuery = query.Encode()
+	}
+
+	return next.String(), nil
+}
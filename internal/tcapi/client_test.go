@@ -0,0 +1,85 @@
+package tcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newPagedServer serves the builds listing as two pages of one build each.
+func newPagedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("start") == "" {
+			fmt.Fprint(w, `{"count": 1, "nextHref": "/app/rest/builds?locator=start:1&start=1", "build": [{"id": 1, "buildTypeId": "A"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"count": 1, "build": [{"id": 2, "buildTypeId": "B"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestListBuilds(t *testing.T) {
+	server := newPagedServer(t)
+	client := NewClient(server.URL, server.Client(), nil)
+
+	builds, err := client.ListBuilds(context.Background(), "project:id:_Root", "id,buildTypeId", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 2 || builds[0].ID != 1 || builds[1].ID != 2 {
+		t.Errorf("builds = %+v, want builds 1 and 2", builds)
+	}
+}
+
+func TestListBuildsPageLimit(t *testing.T) {
+	server := newPagedServer(t)
+	client := NewClient(server.URL, server.Client(), nil)
+
+	builds, err := client.ListBuilds(context.Background(), "project:id:_Root", "id,buildTypeId", 1)
+	if !errors.Is(err, ErrPageLimit) {
+		t.Errorf("err = %v, want ErrPageLimit", err)
+	}
+	if len(builds) != 1 || builds[0].ID != 1 {
+		t.Errorf("builds = %+v, want the first page's build", builds)
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	current := "http://teamcity/app/rest/builds?locator=count:100&fields=count,nextHref,build(id)"
+
+	tests := []struct {
+		name string
+		href string
+		want string
+	}{
+		{"last page", "", ""},
+		{
+			"relative link",
+			"/app/rest/builds?locator=count:100,start:100",
+			"http://teamcity/app/rest/builds?fields=count%2CnextHref%2Cbuild%28id%29&locator=count%3A100%2Cstart%3A100",
+		},
+		{
+			"link with fields",
+			"/app/rest/builds?locator=count:100,start:100&fields=count",
+			"http://teamcity/app/rest/builds?locator=count:100,start:100&fields=count",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NextPageURL(current, test.href)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("NextPageURL() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package tcapi
+
+import (
+	"strings"
+	"time"
+)
+
+// TimeLayout is the layout of the timestamps in TeamCity REST API responses and locators.
+const TimeLayout = "20060102T150405-0700"
+
+type Time struct {
+	time.Time
+}
+
+// UnmarshalJSON parses a TeamCity timestamp. Builds that have not started or finished yet come back with an empty or
+// null date, which leaves the time zero instead of failing the whole response.
+func (t *Time) UnmarshalJSON(b []byte) error {
+	text := strings.Trim(string(b), "\"")
+	if text == "" || text == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	tm, err := time.Parse(TimeLayout, text)
+	t.Time = tm
+	return err
+}
@@ -0,0 +1,45 @@
+package tcapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want time.Time
+		err  bool
+	}{
+		{"timestamp", `"20240101T120000+0100"`, time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC), false},
+		{"empty string", `""`, time.Time{}, false},
+		{"null", `null`, time.Time{}, false},
+		{"malformed", `"2024-01-01T12:00:00Z"`, time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got Time
+			err := json.Unmarshal([]byte(test.json), &got)
+			if (err != nil) != test.err {
+				t.Fatalf("Unmarshal(%s) error = %v", test.json, err)
+			}
+			if !test.err && !got.Equal(test.want) {
+				t.Errorf("Unmarshal(%s) = %v, want %v", test.json, got.Time, test.want)
+			}
+		})
+	}
+}
+
+func TestTimeMissingFromBuild(t *testing.T) {
+	var build Build
+	err := json.Unmarshal([]byte(`{"id": 1, "state": "queued", "startDate": "", "finishDate": null}`), &build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !build.QueuedDate.IsZero() || !build.StartDate.IsZero() || !build.FinishDate.IsZero() {
+		t.Errorf("dates of a queued build = %v, %v, %v, want them zero", build.QueuedDate, build.StartDate, build.FinishDate)
+	}
+}
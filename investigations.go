@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+type ProjectReference struct {
+	ID string `json:"id"`
+}
+
+type ScopeBuildTypes struct {
+	BuildTypes []BuildType `json:"buildType"`
+}
+
+type Scope struct {
+	Project    *ProjectReference `json:"project,omitempty"`
+	BuildTypes ScopeBuildTypes   `json:"buildTypes,omitempty"`
+}
+
+// ProjectID returns the project a problem scope belongs to, scopes limited to build types belong to the project of
+// the first build type.
+func (scope Scope) ProjectID() string {
+	if scope.Project != nil {
+		return scope.Project.ID
+	}
+	for _, buildType := range scope.BuildTypes.BuildTypes {
+		return buildType.ProjectID
+	}
+	return ""
+}
+
+type TargetCount struct {
+	Count uint64 `json:"count"`
+}
+
+type ProblemTarget struct {
+	AnyProblem bool         `json:"anyProblem,omitempty"`
+	Tests      *TargetCount `json:"tests,omitempty"`
+	Problems   *TargetCount `json:"problems,omitempty"`
+}
+
+// Kind returns what an investigation or mute targets: tests, build problems, or any problem of the build types.
+func (target ProblemTarget) Kind() string {
+	if target.Tests != nil && target.Tests.Count > 0 {
+		return "test"
+	}
+	if target.Problems != nil && target.Problems.Count > 0 {
+		return "problem"
+	}
+	return "build_type"
+}
+
+type Investigation struct {
+	ID       string        `json:"id"`
+	State    string        `json:"state"`
+	Assignee *User         `json:"assignee,omitempty"`
+	Scope    Scope         `json:"scope,omitempty"`
+	Target   ProblemTarget `json:"target,omitempty"`
+}
+
+type InvestigationsResponse struct {
+	Page
+	Investigations []Investigation `json:"investigation"`
+}
+
+type Mute struct {
+	ID     uint64        `json:"id"`
+	Scope  Scope         `json:"scope,omitempty"`
+	Target ProblemTarget `json:"target,omitempty"`
+}
+
+type MutesResponse struct {
+	Page
+	Mutes []Mute `json:"mute"`
+}
+
+type TeamCityInvestigationsCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+	root   string
+	filter *ProjectFilter
+
+	investigations *prometheus.Desc
+	mutedTests     *prometheus.Desc
+	mutedProblems  *prometheus.Desc
+}
+
+func NewTeamCityInvestigationsCollector(server *Server) *TeamCityInvestigationsCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityInvestigationsCollector{
+		// Set the TeamCity client and address, and the project tree the project filter walks.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+		root:   viper.GetString("root.project.id"),
+		filter: server.Filter,
+
+		// Investigation and mute metric descriptions.
+		investigations: prometheus.NewDesc(
+			"teamcity_investigations_open",
+			"The number of open TeamCity investigations.",
+			[]string{"project_id", "assignee", "target"},
+			constLabels,
+		),
+		mutedTests: prometheus.NewDesc(
+			"teamcity_muted_tests_total",
+			"The total number of muted TeamCity tests.",
+			[]string{"project_id"},
+			constLabels,
+		),
+		mutedProblems: prometheus.NewDesc(
+			"teamcity_muted_problems_total",
+			"The total number of muted TeamCity build problems.",
+			[]string{"project_id"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityInvestigationsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.investigations
+	ch <- collector.mutedTests
+	ch <- collector.mutedProblems
+}
+
+func (collector TeamCityInvestigationsCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity investigation metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	// The investigations and mutes of projects excluded by the project filter are dropped.
+	included, err := collector.filter.IncludedProjects(ctx, collector.api, collector.root)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "investigations")
+		return
+	}
+
+	err = collector.collectInvestigationMetrics(ctx, included, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "investigations")
+	}
+
+	err = collector.collectMuteMetrics(ctx, included, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "investigations")
+	}
+}
+
+// collectInvestigationMetrics collects the open investigations of the included projects, a nil set including every
+// project.
+func (collector *TeamCityInvestigationsCollector) collectInvestigationMetrics(ctx context.Context, included map[string]bool, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/rest/investigations?locator=state:taken,count:%d&fields=count,nextHref,investigation(id,state,assignee(username),scope(project(id),buildTypes(buildType(id,projectId))),target(anyProblem,tests(count),problems(count)))",
+		collector.addr,
+		viper.GetUint("page.count"),
+	)
+
+	// Follow the next page links until all open investigations are gathered.
+	investigations := InvestigationsResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page InvestigationsResponse) {
+		investigations.Investigations = append(investigations.Investigations, page.Investigations...)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(investigations.Investigations)}).Info("found open investigations")
+
+	// Count the investigations by project, assignee, and target.
+	counts := map[[3]string]int{}
+	for _, investigation := range investigations.Investigations {
+		if included != nil && !included[investigation.Scope.ProjectID()] {
+			continue
+		}
+
+		assignee := ""
+		if investigation.Assignee != nil {
+			assignee = investigation.Assignee.Username
+		}
+		counts[[3]string{investigation.Scope.ProjectID(), assignee, investigation.Target.Kind()}]++
+	}
+
+	// Set the open investigations metric.
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			collector.investigations,
+			prometheus.GaugeValue,
+			float64(count),
+			key[:]...,
+		)
+	}
+
+	return nil
+}
+
+// collectMuteMetrics collects the mutes of the included projects, a nil set including every project.
+func (collector *TeamCityInvestigationsCollector) collectMuteMetrics(ctx context.Context, included map[string]bool, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/rest/mutes?locator=count:%d&fields=count,nextHref,mute(id,scope(project(id),buildTypes(buildType(id,projectId))),target(tests(count),problems(count)))",
+		collector.addr,
+		viper.GetUint("page.count"),
+	)
+
+	// Follow the next page links until all mutes are gathered.
+	mutes := MutesResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page MutesResponse) {
+		mutes.Mutes = append(mutes.Mutes, page.Mutes...)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(mutes.Mutes)}).Info("found mutes")
+
+	// Count the muted tests and problems by project, a single mute can cover several of them.
+	tests, problems := map[string]uint64{}, map[string]uint64{}
+	for _, mute := range mutes.Mutes {
+		project := mute.Scope.ProjectID()
+		if included != nil && !included[project] {
+			continue
+		}
+		if mute.Target.Tests != nil {
+			tests[project] += mute.Target.Tests.Count
+		}
+		if mute.Target.Problems != nil {
+			problems[project] += mute.Target.Problems.Count
+		}
+	}
+
+	// Set the muted tests and problems metrics.
+	for project, count := range tests {
+		ch <- prometheus.MustNewConstMetric(
+			collector.mutedTests,
+			prometheus.GaugeValue,
+			float64(count),
+			project,
+		)
+	}
+	for project, count := range problems {
+		ch <- prometheus.MustNewConstMetric(
+			collector.mutedProblems,
+			prometheus.GaugeValue,
+			float64(count),
+			project,
+		)
+	}
+
+	return nil
+}
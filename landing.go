@@ -0,0 +1,54 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+var landingPage = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>TeamCity Exporter</title></head>
+<body>
+<h1>TeamCity Exporter</h1>
+<p>Version {{ .Version }} ({{ .Commit }}, built {{ .Date }})</p>
+<p><a href="{{ .MetricsPath }}">Metrics</a></p>
+<p><a href="version">Version</a></p>
+</body>
+</html>
+`))
+
+// LandingPageHandler serves a minimal page linking to the metrics endpoint on the root path, so opening the exporter
+// in a browser does not end on a 404.
+type LandingPageHandler struct {
+	metricsPath string
+}
+
+func NewLandingPageHandler(metricsPath string) *LandingPageHandler {
+	return &LandingPageHandler{metricsPath: metricsPath}
+}
+
+func (handler *LandingPageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The root pattern matches every path no other handler is registered for.
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := landingPage.Execute(w, struct {
+		Version     string
+		Commit      string
+		Date        string
+		MetricsPath string
+	}{
+		Version:     version,
+		Commit:      commit,
+		Date:        date,
+		MetricsPath: handler.metricsPath,
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}
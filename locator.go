@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// requiredBuildsLocatorDimensions are the builds locator dimensions generated by the exporter, extra locator clauses
+// must not override them.
+var requiredBuildsLocatorDimensions = []string{"count", "start", "project", "running", "branch", "sinceDate"}
+
+// LocatorDimensions splits a TeamCity locator into its top-level dimensions, keyed by name. Commas nested in
+// parentheses belong to the value of their dimension.
+func LocatorDimensions(locator string) (map[string]string, error) {
+	dimensions := map[string]string{}
+
+	depth := 0
+	clauses := []string{}
+	clause := strings.Builder{}
+	for _, r := range locator {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in locator %q", locator)
+			}
+		case ',':
+			if depth == 0 {
+				clauses = append(clauses, clause.String())
+				clause.Reset()
+				continue
+			}
+		}
+		clause.WriteRune(r)
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in locator %q", locator)
+	}
+	clauses = append(clauses, clause.String())
+
+	for _, clause := range clauses {
+		name, value, ok := strings.Cut(strings.TrimSpace(clause), ":")
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("malformed locator clause %q, expected name:value", clause)
+		}
+		if _, duplicate := dimensions[name]; duplicate {
+			return nil, fmt.Errorf("duplicate locator dimension %q", name)
+		}
+		dimensions[name] = value
+	}
+
+	return dimensions, nil
+}
+
+// ValidateBuildsLocatorExtra checks that the extra builds locator clauses are well-formed and do not conflict with the
+// dimensions generated by the exporter.
+func ValidateBuildsLocatorExtra(extra string) error {
+	if strings.TrimSpace(extra) == "" {
+		return nil
+	}
+
+	dimensions, err := LocatorDimensions(extra)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range requiredBuildsLocatorDimensions {
+		if _, ok := dimensions[name]; ok {
+			return fmt.Errorf("extra builds locator must not set the %q dimension", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidateBuildsLocatorExtra(t *testing.T) {
+	tests := []struct {
+		extra string
+		err   bool
+	}{
+		{"", false},
+		{"personal:false,pinned:true", false},
+		{"tag:(name:release,private:false)", false},
+		{"personal:false,branch:(default:true)", true},
+		{"sinceDate:20240101T000000+0000", true},
+		{"personal:false,personal:true", true},
+		{"pinned", true},
+		{"tag:(name:release", true},
+	}
+
+	for _, test := range tests {
+		err := ValidateBuildsLocatorExtra(test.extra)
+		if (err != nil) != test.err {
+			t.Errorf("ValidateBuildsLocatorExtra(%q) error = %v", test.extra, err)
+		}
+	}
+}
+
+func TestBuildsLocatorExtraAppended(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root", "builds.locator_extra": "personal:false,pinned:true"})
+	var mutex sync.Mutex
+	var locator string
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		locator = r.URL.Query().Get("locator")
+		mutex.Unlock()
+		fmt.Fprint(w, `{"count": 0}`)
+	}))
+
+	testutil.CollectAndCount(NewTeamCityBuildsCollector(server))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	dimensions, err := LocatorDimensions(locator)
+	if err != nil {
+		t.Fatalf("locator %q is malformed: %s", locator, err)
+	}
+	if dimensions["project"] != "id:_Root" || dimensions["personal"] != "false" || dimensions["pinned"] != "true" {
+		t.Errorf("locator = %q, want the project locator along with the extra clauses", locator)
+	}
+}
@@ -1,20 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
-	"github.com/cvbarros/go-teamcity/teamcity"
 	logrus "github.com/sirupsen/logrus"
 	viper "github.com/spf13/viper"
 
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	config := flag.String("config", "", "Path to a YAML, TOML, or JSON configuration file.")
+	showVersion := flag.Bool("version", false, "Print the version and exit.")
+
 	// Setup mapping of environment variables to configuration elements.
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.SetEnvPrefix("TEAMCITY")
@@ -27,11 +34,134 @@ func main() {
 	// Set defaults for TeamCity API configuration.
 	viper.SetDefault("page.count", 10000)
 	viper.SetDefault("root.project.id", "_Root")
+	viper.SetDefault("concurrency", 8)
+	viper.SetDefault("scrape.concurrency", 0)
+	viper.SetDefault("project.include", "")
+	viper.SetDefault("project.exclude", "")
+	_ = viper.BindEnv("project.include", "TEAMCITY_PROJECT_INCLUDE", "TEAMCITY_PROJECTS_INCLUDE")
+	_ = viper.BindEnv("project.exclude", "TEAMCITY_PROJECT_EXCLUDE", "TEAMCITY_PROJECTS_EXCLUDE")
+
+	// Set defaults for retrying failed TeamCity requests, the deprecated retry keys are applied on top of them once the
+	// configuration is read.
+	viper.SetDefault("http.retry.max", 10)
+	viper.SetDefault("http.retry.wait_min", "1s")
+	viper.SetDefault("http.retry.wait_max", "30s")
+	viper.SetDefault("http.retry.log", false)
+	viper.SetDefault("http.request_timeout", 0)
+	viper.SetDefault("rate_limit.requests_per_second", 0)
+	viper.SetDefault("rate_limit.burst", 10)
+
+	// Set defaults for TLS towards TeamCity, the system cert pool verifies the server by default.
+	viper.SetDefault("tls.ca_file", "")
+	viper.SetDefault("tls.insecure_skip_verify", false)
+	viper.SetDefault("tls.cert_file", "")
+	viper.SetDefault("tls.key_file", "")
+	viper.SetDefault("builds.since", "24h")
+	viper.SetDefault("builds.staleness_threshold", "720h")
+	viper.SetDefault("builds.locator_extra", "")
+	viper.SetDefault("builds.collect_error_lines", false)
+	viper.SetDefault("builds.collect_chain_durations", false)
+	viper.SetDefault("builds.comment_regex", "")
+	viper.SetDefault("builds.default_branch_only", false)
+	_ = viper.BindEnv("builds.default_branch_only", "TEAMCITY_BUILDS_DEFAULT_BRANCH_ONLY", "TEAMCITY_DEFAULT_BRANCH_ONLY")
+	viper.SetDefault("builds.branch", "")
+	viper.SetDefault("builds.per_type", 0)
+	viper.SetDefault("builds.max_pages", 100)
+	viper.SetDefault("builds.lookback", 0)
+	viper.SetDefault("builds.retention", 0)
+	viper.SetDefault("builds.incremental", false)
+	viper.SetDefault("builds.history_limit", 10000)
+	viper.SetDefault("builds.duration_buckets", "")
+	viper.SetDefault("builds.timestamps", false)
+	viper.SetDefault("builds.one_hot", false)
+	viper.SetDefault("builds.build_type_name_label", false)
+	viper.SetDefault("builds.labels", defaultBuildLabels)
+	viper.SetDefault("builds.collect_artifacts", false)
+	viper.SetDefault("statistics.keys", "")
+	viper.SetDefault("statistics.builds", 100)
+	viper.SetDefault("agents.idle_build_id", true)
+	viper.SetDefault("agents.compatibility", false)
+	viper.SetDefault("users.active_window", "720h")
+	viper.SetDefault("queue.per_build_type", false)
+	viper.SetDefault("queue.per_reason", true)
+	viper.SetDefault("queue.per_pool", false)
+	viper.SetDefault("deployments.enabled", false)
+	viper.SetDefault("deployments.environment_parameter", "env.DEPLOYMENT_ENVIRONMENT")
+
+	// Set defaults for the enabled collectors, an empty list enables all of them.
+	viper.SetDefault("collectors", "")
+
+	// Set defaults for the collection order, the cheap collectors run before the expensive project tree walks.
+	viper.SetDefault("collect.priority", "agents,pools,queue,projects,builds")
+	viper.SetDefault("collect.deadline", 0)
+	viper.SetDefault("scrape.timeout", 0)
 
 	// Set defaults for exporting metrics.
 	viper.SetDefault("metrics.listen", "0.0.0.0")
 	viper.SetDefault("metrics.path", "/metrics")
 	viper.SetDefault("metrics.port", 2112)
+	viper.SetDefault("metrics.collect_lock_timeout", 0)
+	viper.SetDefault("cache.ttl", 0)
+	viper.SetDefault("collect.interval", 0)
+	viper.SetDefault("metrics.native_histograms", false)
+	viper.SetDefault("metrics.openmetrics", false)
+	viper.SetDefault("metrics.shutdown_grace_period", "30s")
+	viper.SetDefault("metrics.tls.cert", "")
+	viper.SetDefault("metrics.tls.key", "")
+	viper.SetDefault("metrics.tls.client_ca", "")
+
+	// Set defaults for push mode configuration.
+	viper.SetDefault("push.url", "")
+	viper.SetDefault("push.job", "teamcity_exporter")
+	viper.SetDefault("push.interval", 0)
+	viper.SetDefault("push.username", "")
+	viper.SetDefault("push.password", "")
+
+	// Set defaults for the TeamCity server to export and the configuration file, they have no sensible values of their
+	// own but are listed in the usage along with the other keys.
+	viper.SetDefault("addr", "")
+	viper.SetDefault("config", "")
+
+	// Set defaults for authenticating against TeamCity, an empty mode picks one from the configured credentials.
+	viper.SetDefault("auth.mode", "")
+	viper.SetDefault("token", "")
+	viper.SetDefault("username", "")
+	viper.SetDefault("password", "")
+
+	// Set defaults for the optional authentication of the on-demand endpoints, an empty username disables it.
+	viper.SetDefault("web.auth.username", "")
+	viper.SetDefault("web.auth.password", "")
+
+	// Set defaults for debugging the exporter itself.
+	viper.SetDefault("debug.pprof", false)
+	viper.SetDefault("debug.port", 0)
+
+	// Set defaults for the readiness endpoint.
+	viper.SetDefault("readyz.check_root", false)
+
+	// Set defaults for the health endpoint, its timeout keeps a hung TeamCity from hanging the probe.
+	viper.SetDefault("healthz.path", "/healthz")
+	viper.SetDefault("healthz.timeout", "5s")
+
+	// Parse the flags once every default is set, the usage lists them as configuration keys.
+	flag.Usage = func() { PrintUsage(os.Stderr) }
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(VersionString())
+		return
+	}
+
+	// Read the optional configuration file, environment variables take precedence over its values.
+	if *config == "" {
+		*config = viper.GetString("config")
+	}
+	if *config != "" {
+		viper.SetConfigFile(*config)
+		err := viper.ReadInConfig()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"config": *config}).Fatal(err)
+		}
+	}
 
 	// Setup our logging system, first parse and set the level defaulting to INFO if we can't determine it.
 	level, err := logrus.ParseLevel(viper.GetString("logging.level"))
@@ -59,30 +189,211 @@ func main() {
 		"logging.level":  logrus.GetLevel(),
 	}).Debug("logger configuration finished")
 
+	logrus.WithFields(logrus.Fields{"version": version, "commit": commit, "date": date}).Info("starting TeamCity exporter")
+
+	ApplyDeprecatedRetryKeys()
+
 	logrus.Info("initialize TeamCity exporter configuration")
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = 10
-	retryClient.Logger = nil
+	servers, err := ConfiguredServers()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	for _, server := range servers {
+		err := server.Connect()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"server": server.Name}).Fatal(err)
+		}
+	}
+
+	err = ValidateBuildsLocatorExtra(viper.GetString("builds.locator_extra"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	httpClient := retryClient.StandardClient()
+	_, err = ParseDurationBuckets(viper.GetString("builds.duration_buckets"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	client, err := teamcity.NewClientWithAddress(
-		teamcity.TokenAuth(viper.GetString("token")),
-		viper.GetString("addr"),
-		httpClient,
-	)
+	_, err = ParseBuildLabels(viper.GetString("builds.labels"))
 	if err != nil {
-		logrus.Error(err)
+		logrus.Fatal(err)
 	}
 
-	logrus.Info("registering TeamCity metrics collector")
-	prometheus.MustRegister(NewTeamCityAgentCollector(client))
-	prometheus.MustRegister(NewTeamCityBuildsCollector(client))
-	prometheus.MustRegister(NewTeamCityProjectsCollector(client))
+	_, err = NewCommentAnnotator(viper.GetString("builds.comment_regex"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	http.Handle(viper.GetString("metrics.path"), promhttp.Handler())
-	err = http.ListenAndServe(fmt.Sprintf("%s:%d", viper.GetString("metrics.listen"), viper.GetInt("metrics.port")), nil)
+	_, err = ConfiguredBranchFilter()
 	if err != nil {
 		logrus.Fatal(err)
 	}
+
+	collectors, err := EnabledCollectors(viper.GetString("collectors"))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// Run a set of collectors per server, the metrics of named servers carry a server label to tell them apart.
+	cached := []*CachedCollector{}
+	for _, server := range servers {
+		server.Filter, err = ConfiguredProjectFilter()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		server.Semaphore = NewScrapeSemaphore()
+
+		serverCached := []*CachedCollector{}
+		for _, name := range PrioritizeCollectors(collectors, viper.GetString("collect.priority")) {
+			logrus.WithFields(logrus.Fields{"server": server.Name, "collector": name}).Info("registering TeamCity metrics collector")
+			serverCached = append(serverCached, NewCachedCollector(
+				server.Name,
+				name,
+				collectorFactories[name](server),
+				viper.GetDuration("metrics.collect_lock_timeout"),
+				viper.GetDuration("cache.ttl"),
+			))
+		}
+
+		registerer := prometheus.DefaultRegisterer
+		if server.Name != "" {
+			registerer = prometheus.WrapRegistererWith(prometheus.Labels{"server": server.Name}, registerer)
+		}
+		registerer.MustRegister(NewCompositeCollector(serverCached, viper.GetDuration("collect.deadline")))
+		cached = append(cached, serverCached...)
+	}
+	prometheus.MustRegister(scrapeLockTimeouts)
+	prometheus.MustRegister(collectorPanics)
+	prometheus.MustRegister(scrapeErrors)
+	prometheus.MustRegister(scrapeSuccess)
+	prometheus.MustRegister(partialScrape)
+	prometheus.MustRegister(collectorDuration)
+	prometheus.MustRegister(collectorLastScrape)
+	prometheus.MustRegister(apiRequests)
+	prometheus.MustRegister(apiRetries)
+	prometheus.MustRegister(buildInfo)
+
+	// In push mode the metrics are pushed to a Pushgateway instead of being served, e.g. where TeamCity runs out of
+	// reach of Prometheus.
+	if viper.GetString("push.url") != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err = PushMetrics(ctx, NewPusher(), viper.GetDuration("push.interval"))
+		cancelCollections()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	// Use our own mux, the default one has the profiling handlers registered as soon as they are imported.
+	mux := http.NewServeMux()
+	collect := RequireBasicAuth(NewCollectHandler(servers, collectors))
+	mux.Handle(viper.GetString("metrics.path"), MultiTargetHandler(
+		promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+				EnableOpenMetrics: viper.GetBool("metrics.openmetrics"),
+			}),
+		),
+		collect,
+	))
+	mux.Handle("/", NewLandingPageHandler(viper.GetString("metrics.path")))
+	mux.Handle("/version", VersionHandler{})
+
+	// The probes check every server, the on-demand endpoints take the server to look at as a query parameter.
+	mux.Handle("/readyz", NewReadinessHandler(servers))
+	mux.Handle(viper.GetString("healthz.path"), NewHealthHandler(servers, viper.GetDuration("healthz.timeout")))
+	mux.Handle("/collect", collect)
+	mux.Handle("/debug/config", RequireBasicAuth(NewDiagnosticsHandler(servers, collectors)))
+
+	// With a debug port the profiling handlers move to their own server, along with the runtime metrics, so that
+	// they are not exposed to whoever can reach the metrics.
+	debugMux := mux
+	var debugServer *http.Server
+	if port := viper.GetInt("debug.port"); port > 0 {
+		debugMux = NewDebugMux()
+		debugServer = &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", viper.GetString("metrics.listen"), port),
+			Handler: debugMux,
+		}
+	}
+
+	if viper.GetBool("debug.pprof") {
+		logrus.Info("registering profiling handlers")
+		RegisterProfilingHandlers(debugMux)
+	}
+
+	metricsTLSConfig, err := MetricsTLSConfig()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	server := &http.Server{
+		Addr:      fmt.Sprintf("%s:%d", viper.GetString("metrics.listen"), viper.GetInt("metrics.port")),
+		Handler:   mux,
+		TLSConfig: metricsTLSConfig,
+	}
+
+	// Serve until we are asked to stop, e.g. by Kubernetes sending SIGTERM before killing the pod.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Refresh the collections in the background when an interval is set, scrapes then serve the latest metrics.
+	if interval := viper.GetDuration("collect.interval"); interval > 0 {
+		logrus.WithFields(logrus.Fields{"interval": interval}).Info("refreshing collections in the background")
+		for _, collector := range cached {
+			go collector.Refresh(ctx, interval)
+		}
+	}
+
+	go func() {
+		logrus.WithFields(logrus.Fields{"addr": server.Addr, "tls": server.TLSConfig != nil}).Info("serving metrics")
+
+		// The certificate is already loaded into the TLS configuration, no files need to be given here.
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Fatal(err)
+		}
+	}()
+
+	if debugServer != nil {
+		go func() {
+			logrus.WithFields(logrus.Fields{"addr": debugServer.Addr}).Info("serving debug endpoints")
+			err := debugServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logrus.Fatal(err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	stop()
+
+	// Stop accepting connections and give the in-flight scrapes the grace period to finish.
+	grace := viper.GetDuration("metrics.shutdown_grace_period")
+	logrus.WithFields(logrus.Fields{"grace_period": grace}).Info("shutting down, waiting for in-flight requests")
+	shutdown, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	err = server.Shutdown(shutdown)
+	if debugServer != nil {
+		// Profiles can outlast the grace period, they are cut short rather than holding up the shutdown.
+		debugServer.Close()
+	}
+
+	// Cancel whatever collection work is left, e.g. background refreshes or scrapes that outlived the grace period.
+	cancelCollections()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Warn("in-flight requests did not finish within the grace period, cancelled their collections")
+		return
+	}
+	logrus.Info("shutdown finished")
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+)
+
+type AgentPool struct {
+	ID        uint64         `json:"id"`
+	Name      string         `json:"name"`
+	MaxAgents *int64         `json:"maxAgents,omitempty"`
+	Agents    AgentsResponse `json:"agents,omitempty"`
+	Projects  ProjectsCount  `json:"projects,omitempty"`
+}
+
+type ProjectsCount struct {
+	Count uint64 `json:"count"`
+}
+
+type AgentPoolsResponse struct {
+	Page
+	AgentPools []AgentPool `json:"agentPool"`
+}
+
+type TeamCityAgentPoolsCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+
+	poolMaxAgents *prometheus.Desc
+	poolAgents    *prometheus.Desc
+	poolProjects  *prometheus.Desc
+	poolConnected *prometheus.Desc
+	poolIdle      *prometheus.Desc
+	poolBusy      *prometheus.Desc
+}
+
+func NewTeamCityAgentPoolsCollector(server *Server) *TeamCityAgentPoolsCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityAgentPoolsCollector{
+		// Set the TeamCity client and address.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+
+		// Agent pool metric descriptions.
+		poolMaxAgents: prometheus.NewDesc(
+			"teamcity_agent_pool_max_agents",
+			"The maximum number of agents of a TeamCity agent pool.",
+			[]string{"pool_id", "pool_name"},
+			constLabels,
+		),
+		poolAgents: prometheus.NewDesc(
+			"teamcity_agent_pool_agents_total",
+			"The total number of agents in a TeamCity agent pool.",
+			[]string{"pool_id", "pool_name"},
+			constLabels,
+		),
+		poolProjects: prometheus.NewDesc(
+			"teamcity_agent_pool_projects_total",
+			"The total number of projects assigned to a TeamCity agent pool.",
+			[]string{"pool_id", "pool_name"},
+			constLabels,
+		),
+		poolConnected: prometheus.NewDesc(
+			"teamcity_agent_pool_connected_agents",
+			"The number of connected agents in a TeamCity agent pool.",
+			[]string{"pool_id", "pool_name"},
+			constLabels,
+		),
+		poolIdle: prometheus.NewDesc(
+			"teamcity_agent_pool_idle_agents",
+			"The number of connected agents in a TeamCity agent pool not running a build.",
+			[]string{"pool_id", "pool_name"},
+			constLabels,
+		),
+		poolBusy: prometheus.NewDesc(
+			"teamcity_agent_pool_busy_agents",
+			"The number of agents in a TeamCity agent pool running a build.",
+			[]string{"pool_id", "pool_name"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityAgentPoolsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.poolMaxAgents
+	ch <- collector.poolAgents
+	ch <- collector.poolProjects
+	ch <- collector.poolConnected
+	ch <- collector.poolIdle
+	ch <- collector.poolBusy
+}
+
+func (collector TeamCityAgentPoolsCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity agent pool metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectAgentPoolMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "pools")
+	}
+}
+
+func (collector *TeamCityAgentPoolsCollector) collectAgentPoolMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/rest/agentPools?fields=count,nextHref,agentPool(id,name,maxAgents,agents(count,agent(id,connected,build(id))),projects(count))",
+		collector.addr,
+	)
+
+	// Follow the next page links until all agent pools are gathered.
+	pools := AgentPoolsResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page AgentPoolsResponse) {
+		pools.AgentPools = append(pools.AgentPools, page.AgentPools...)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(pools.AgentPools)}).Info("found agent pools")
+	for _, pool := range pools.AgentPools {
+		labels := []string{fmt.Sprintf("%d", pool.ID), pool.Name}
+
+		// Set the maximum agents metric, pools without a limit do not report one.
+		if pool.MaxAgents != nil {
+			ch <- prometheus.MustNewConstMetric(
+				collector.poolMaxAgents,
+				prometheus.GaugeValue,
+				float64(*pool.MaxAgents),
+				labels...,
+			)
+		}
+
+		// Set the agent count metric.
+		ch <- prometheus.MustNewConstMetric(
+			collector.poolAgents,
+			prometheus.GaugeValue,
+			float64(pool.Agents.Count),
+			labels...,
+		)
+
+		// Set the project count metric.
+		ch <- prometheus.MustNewConstMetric(
+			collector.poolProjects,
+			prometheus.GaugeValue,
+			float64(pool.Projects.Count),
+			labels...,
+		)
+
+		// Set the connected, idle, and busy agent metrics.
+		connected, busy := 0, 0
+		for _, agent := range pool.Agents.Agents {
+			if agent.Connected {
+				connected++
+			}
+			if agent.Busy() {
+				busy++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(
+			collector.poolConnected,
+			prometheus.GaugeValue,
+			float64(connected),
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			collector.poolIdle,
+			prometheus.GaugeValue,
+			float64(connected-busy),
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			collector.poolBusy,
+			prometheus.GaugeValue,
+			float64(busy),
+			labels...,
+		)
+	}
+
+	return nil
+}
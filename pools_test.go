@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAgentPoolsCollector(t *testing.T) {
+	server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/agentPools": fixture(t, "agent_pools.json")}))
+
+	// The default pool has no agent limit, the saturated Linux pool has no idle agent left.
+	expected := `
+# HELP teamcity_agent_pool_agents_total The total number of agents in a TeamCity agent pool.
+# TYPE teamcity_agent_pool_agents_total gauge
+teamcity_agent_pool_agents_total{pool_id="0",pool_name="Default"} 3
+teamcity_agent_pool_agents_total{pool_id="1",pool_name="Linux"} 2
+# HELP teamcity_agent_pool_busy_agents The number of agents in a TeamCity agent pool running a build.
+# TYPE teamcity_agent_pool_busy_agents gauge
+teamcity_agent_pool_busy_agents{pool_id="0",pool_name="Default"} 1
+teamcity_agent_pool_busy_agents{pool_id="1",pool_name="Linux"} 2
+# HELP teamcity_agent_pool_connected_agents The number of connected agents in a TeamCity agent pool.
+# TYPE teamcity_agent_pool_connected_agents gauge
+teamcity_agent_pool_connected_agents{pool_id="0",pool_name="Default"} 2
+teamcity_agent_pool_connected_agents{pool_id="1",pool_name="Linux"} 2
+# HELP teamcity_agent_pool_idle_agents The number of connected agents in a TeamCity agent pool not running a build.
+# TYPE teamcity_agent_pool_idle_agents gauge
+teamcity_agent_pool_idle_agents{pool_id="0",pool_name="Default"} 1
+teamcity_agent_pool_idle_agents{pool_id="1",pool_name="Linux"} 0
+# HELP teamcity_agent_pool_max_agents The maximum number of agents of a TeamCity agent pool.
+# TYPE teamcity_agent_pool_max_agents gauge
+teamcity_agent_pool_max_agents{pool_id="1",pool_name="Linux"} 2
+# HELP teamcity_agent_pool_projects_total The total number of projects assigned to a TeamCity agent pool.
+# TYPE teamcity_agent_pool_projects_total gauge
+teamcity_agent_pool_projects_total{pool_id="0",pool_name="Default"} 12
+teamcity_agent_pool_projects_total{pool_id="1",pool_name="Linux"} 1
+`
+	err := testutil.CollectAndCompare(NewTeamCityAgentPoolsCollector(server), strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
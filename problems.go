@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+type ProblemOccurrencesResponse struct {
+	Page
+	ProblemOccurrences []ProblemOccurrence `json:"problemOccurrence"`
+}
+
+type TeamCityProblemsCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+	root   string
+	filter *ProjectFilter
+
+	buildTypeProblems *prometheus.Desc
+}
+
+func NewTeamCityProblemsCollector(server *Server) *TeamCityProblemsCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityProblemsCollector{
+		// Set the TeamCity client and address, and the project to collect.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+		root:   viper.GetString("root.project.id"),
+		filter: server.Filter,
+
+		// Problem metric descriptions.
+		buildTypeProblems: prometheus.NewDesc(
+			"teamcity_build_type_problems",
+			"The number of currently failing problems of a TeamCity build type by problem type.",
+			[]string{"build_type_id", "type"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityProblemsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.buildTypeProblems
+}
+
+func (collector TeamCityProblemsCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity problem metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectProblemMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "problems")
+	}
+}
+
+func (collector *TeamCityProblemsCollector) collectProblemMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// Only the problems of the latest builds are currently failing, fixed problems drop out on the next build.
+	locator := fmt.Sprintf("currentlyFailing:true,affectedProject:(id:%s),count:%d", collector.root, viper.GetUint("page.count"))
+	url := fmt.Sprintf(
+		"%s/app/rest/problemOccurrences?locator=%s&fields=count,nextHref,problemOccurrence(type,build(buildTypeId,buildType(id,projectId)))",
+		collector.addr,
+		neturl.QueryEscape(locator),
+	)
+
+	// The problems of projects excluded by the project filter are dropped.
+	included, err := collector.filter.IncludedProjects(ctx, collector.api, collector.root)
+	if err != nil {
+		return err
+	}
+
+	// Follow the next page links, counting the problems by build type and problem type.
+	problems := map[[2]string]int{}
+	found := 0
+	err = tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page ProblemOccurrencesResponse) {
+		for _, occurrence := range page.ProblemOccurrences {
+			if occurrence.Build == nil {
+				continue
+			}
+			if included != nil && !included[occurrence.Build.BuildType.ProjectID] {
+				continue
+			}
+			problems[[2]string{occurrence.Build.BuildTypeID, occurrence.Type}]++
+			found++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": found}).Info("found currently failing problems")
+
+	// Set the problems metric for each build type and problem type with currently failing problems.
+	for key, count := range problems {
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeProblems,
+			prometheus.GaugeValue,
+			float64(count),
+			key[:]...,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProblemsCollectorProjectFilter(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root"})
+
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/problemOccurrences": `{"count": 2, "problemOccurrence": [
+			{"type": "TC_EXIT_CODE", "build": {"buildTypeId": "Team_Build", "buildType": {"id": "Team_Build", "projectId": "Team"}}},
+			{"type": "TC_EXIT_CODE", "build": {"buildTypeId": "Archive_Build", "buildType": {"id": "Archive_Build", "projectId": "Archive"}}}
+		]}`,
+	}))
+	server.API.Projects = projectTree("Team", "Archive")
+
+	collector := NewTeamCityProblemsCollector(server)
+	filter, err := NewProjectFilter("", "^Archive$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.filter = filter
+
+	expected := `
+# HELP teamcity_build_type_problems The number of currently failing problems of a TeamCity build type by problem type.
+# TYPE teamcity_build_type_problems gauge
+teamcity_build_type_problems{build_type_id="Team_Build",type="TC_EXIT_CODE"} 1
+`
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
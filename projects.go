@@ -1,94 +1,251 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 
-	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
 	"github.com/prometheus/client_golang/prometheus"
 	logrus "github.com/sirupsen/logrus"
 	viper "github.com/spf13/viper"
 )
 
+type BuildTypesResponse struct {
+	Page
+	BuildTypes []BuildType `json:"buildType"`
+}
+
+// projectsScrape holds the state shared by every project visited during a single collection.
+type projectsScrape struct {
+	favorites map[string]bool
+
+	// The number of build types without a VCS trigger, updated atomically by the project goroutines.
+	withoutVCSTrigger int64
+}
+
 type TeamCityProjectsCollector struct {
-	client *teamcity.Client
+	api    *tcapi.Client
+	server string
+	addr   string
+	root   string
+
+	// Bounds the number of projects collected concurrently across the whole project tree.
+	semaphore Semaphore
+	filter    *ProjectFilter
 
-	buildTypes *prometheus.Desc
-	projects   *prometheus.Desc
+	buildTypeInfo               *prometheus.Desc
+	buildTypePaused             *prometheus.Desc
+	buildTypeFavorite           *prometheus.Desc
+	buildTypeHasVCSTrigger      *prometheus.Desc
+	buildTypeParameters         *prometheus.Desc
+	deploymentStatus            *prometheus.Desc
+	buildTypes                  *prometheus.Desc
+	buildTypesWithoutVCSTrigger *prometheus.Desc
+	projects                    *prometheus.Desc
 }
 
-func NewTeamCityProjectsCollector(client *teamcity.Client) *TeamCityProjectsCollector {
+func NewTeamCityProjectsCollector(server *Server) *TeamCityProjectsCollector {
 	constLabels := prometheus.Labels{}
 
 	return &TeamCityProjectsCollector{
-		// Set the TeamCity client.
-		client: client,
+		// Set the TeamCity client and address, and the project to collect.
+		api:       server.API,
+		server:    server.Name,
+		addr:      server.Addr,
+		root:      viper.GetString("root.project.id"),
+		semaphore: server.Semaphore,
+		filter:    server.Filter,
 
+		buildTypeInfo: prometheus.NewDesc(
+			"teamcity_build_type_info",
+			"Information about a TeamCity build type.",
+			[]string{"build_type_id", "project_id", "name"},
+			constLabels,
+		),
+		buildTypePaused: prometheus.NewDesc(
+			"teamcity_build_type_paused",
+			"Whether a TeamCity build type is paused.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+		buildTypeFavorite: prometheus.NewDesc(
+			"teamcity_build_type_favorite",
+			"Whether a TeamCity build type has a build marked as favorite.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+		buildTypeHasVCSTrigger: prometheus.NewDesc(
+			"teamcity_build_type_has_vcs_trigger",
+			"Whether a TeamCity build type has a VCS trigger.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+		buildTypeParameters: prometheus.NewDesc(
+			"teamcity_build_type_parameters_total",
+			"The total number of configuration parameters of a TeamCity build type.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
 		buildTypes: prometheus.NewDesc(
 			"teamcity_project_build_types_total",
 			"The total number of build types for a TeamCity project.",
-			[]string{"project_id"},
+			[]string{"project_id", "project_name"},
+			constLabels,
+		),
+		buildTypesWithoutVCSTrigger: prometheus.NewDesc(
+			"teamcity_build_types_without_vcs_trigger_total",
+			"The total number of TeamCity build types without a VCS trigger.",
+			[]string{},
+			constLabels,
+		),
+		deploymentStatus: prometheus.NewDesc(
+			"teamcity_deployment_status",
+			"The status of the latest finished build of a TeamCity deployment build type.",
+			[]string{"build_type_id", "environment"},
 			constLabels,
 		),
 		projects: prometheus.NewDesc(
 			"teamcity_projects_total",
 			"The total number of subprojects for a TeamCity project.",
-			[]string{"project_id"},
+			[]string{"project_id", "project_name"},
 			constLabels,
 		),
 	}
 }
 
 func (collector TeamCityProjectsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.buildTypeInfo
+	ch <- collector.buildTypePaused
+	ch <- collector.buildTypeFavorite
+	ch <- collector.buildTypeHasVCSTrigger
+	ch <- collector.buildTypeParameters
 	ch <- collector.buildTypes
+	ch <- collector.buildTypesWithoutVCSTrigger
+	ch <- collector.deploymentStatus
 	ch <- collector.projects
 }
 
 func (collector TeamCityProjectsCollector) Collect(ch chan<- prometheus.Metric) {
 	logrus.Info("collecting TeamCity project metrics")
 
-	err := collector.collectProjectMetrics(viper.GetString("root.project.id"), ch)
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	favorites, err := collector.favoriteBuildTypes(ctx)
 	if err != nil {
 		logrus.Error(err)
+		countScrapeError(collector.server, "projects")
 	}
+
+	scrape := &projectsScrape{favorites: favorites}
+	err = collector.collectProjectMetrics(ctx, collector.root, collector.filter.Root(), scrape, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "projects")
+		return
+	}
+
+	// Set the rollup of build types without a VCS trigger across the whole project tree.
+	ch <- prometheus.MustNewConstMetric(
+		collector.buildTypesWithoutVCSTrigger,
+		prometheus.GaugeValue,
+		float64(atomic.LoadInt64(&scrape.withoutVCSTrigger)),
+	)
 }
 
-func (collector *TeamCityProjectsCollector) collectProjectMetrics(identifier string, ch chan<- prometheus.Metric) error {
+// favoriteBuildTypes returns the set of build type IDs that have a build starred by the exporter's user.
+func (collector *TeamCityProjectsCollector) favoriteBuildTypes(ctx context.Context) (map[string]bool, error) {
+	favorites := map[string]bool{}
+
+	// TeamCity stores favorite builds as a private ".teamcity.star" tag owned by the user who starred them.
+	locator := fmt.Sprintf(
+		"count:%d,defaultFilter:false,tag:(private:true,owner:current,condition:(value:.teamcity.star))",
+		viper.GetUint("page.count"),
+	)
+
+	builds, err := collector.api.ListBuilds(ctx, locator, "id,buildTypeId", 0)
+	for _, build := range builds {
+		favorites[build.BuildTypeID] = true
+	}
+	return favorites, err
+}
+
+// collectProjectMetrics collects the metrics of a project and its subprojects. The parent flag tells whether the
+// parent project is included by the project filter.
+func (collector *TeamCityProjectsCollector) collectProjectMetrics(ctx context.Context, identifier string, parent bool, scrape *projectsScrape, ch chan<- prometheus.Metric) error {
 	logger := logrus.WithFields(logrus.Fields{"project": identifier})
 
+	if !collector.filter.Walk(identifier, parent) {
+		logger.Debug("skipping excluded project")
+		return nil
+	}
+	included := collector.filter.Included(identifier, parent)
+
+	// Only hold a concurrency slot for the project's own requests, holding it while waiting on the subprojects would
+	// deadlock once the project tree is deeper than the concurrency limit.
+	release := collector.semaphore.Acquire()
+	defer release()
+
 	logger.Info("collecting project")
-	p, err := collector.client.Projects.GetByID(identifier)
+	p, err := collector.api.GetProject(ctx, identifier)
 	if err != nil {
 		return err
 	}
 
-	// Set the subproject count metric.
-	logger.WithFields(logrus.Fields{"value": p.ChildProjects.Count}).Debug("setting project count metric")
-	ch <- prometheus.MustNewConstMetric(
-		collector.projects,
-		prometheus.GaugeValue,
-		float64(p.ChildProjects.Count),
-		p.ID,
-	)
+	// Only set the metrics of projects included by the project filter, their subprojects are walked regardless.
+	if included {
+		// Set the subproject count metric.
+		logger.WithFields(logrus.Fields{"value": p.ChildProjects.Count}).Debug("setting project count metric")
+		ch <- prometheus.MustNewConstMetric(
+			collector.projects,
+			prometheus.GaugeValue,
+			float64(p.ChildProjects.Count),
+			p.ID, p.Name,
+		)
 
-	// Set the build type count metric.
-	logger.WithFields(logrus.Fields{"value": p.BuildTypes.Count}).Debug("setting build type count metric")
-	ch <- prometheus.MustNewConstMetric(
-		collector.buildTypes,
-		prometheus.GaugeValue,
-		float64(p.BuildTypes.Count),
-		p.ID,
-	)
+		// Set the build type count metric.
+		logger.WithFields(logrus.Fields{"value": p.BuildTypes.Count}).Debug("setting build type count metric")
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypes,
+			prometheus.GaugeValue,
+			float64(p.BuildTypes.Count),
+			p.ID, p.Name,
+		)
+
+		// Set the favorite metric for each of the project's build types.
+		for _, buildType := range p.BuildTypes.Items {
+			ch <- prometheus.MustNewConstMetric(
+				collector.buildTypeFavorite,
+				prometheus.GaugeValue,
+				float64(map[bool]int{true: 1, false: 0}[scrape.favorites[buildType.ID]]),
+				buildType.ID,
+			)
+		}
+
+		// Set the info, paused, VCS trigger, and parameter metrics for each of the project's build types.
+		err = collector.collectBuildTypeMetrics(ctx, p.ID, scrape, ch)
+		if err != nil {
+			logger.Error(err)
+			countScrapeError(collector.server, "projects")
+		}
+	}
+
+	release()
 
 	// Collect metrics on the subprojects.
 	wg := sync.WaitGroup{}
-	wg.Add(p.ChildProjects.Count)
 	for _, subproject := range p.ChildProjects.Items {
+		wg.Add(1)
 		go func(identifier string) {
-			err := collector.collectProjectMetrics(identifier, ch)
+			defer wg.Done()
+			defer recoverCollectorPanic(collector.server, "projects")
+			err := collector.collectProjectMetrics(ctx, identifier, included, scrape, ch)
 			if err != nil {
 				logger.Error(err)
+				countScrapeError(collector.server, "projects")
 			}
-			wg.Done()
 		}(subproject.ID)
 	}
 
@@ -98,3 +255,101 @@ func (collector *TeamCityProjectsCollector) collectProjectMetrics(identifier str
 
 	return nil
 }
+
+func (collector *TeamCityProjectsCollector) collectBuildTypeMetrics(ctx context.Context, identifier string, scrape *projectsScrape, ch chan<- prometheus.Metric) error {
+	// The settings and parameter values are only needed to collect the deployment metrics.
+	fields := "id,name,projectId,paused,parameters(count),triggers(trigger(type))"
+	if viper.GetBool("deployments.enabled") {
+		fields = "id,name,projectId,paused,parameters(count,property(name,value)),settings(property(name,value)),triggers(trigger(type))"
+	}
+
+	url := fmt.Sprintf(
+		"%s/app/rest/buildTypes?locator=count:%d,project:(id:%s)&fields=count,nextHref,buildType(%s)",
+		collector.addr,
+		viper.GetUint("page.count"),
+		identifier,
+		fields,
+	)
+
+	// Follow the next page links until all build types are gathered.
+	buildTypes := BuildTypesResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page BuildTypesResponse) {
+		buildTypes.BuildTypes = append(buildTypes.BuildTypes, page.BuildTypes...)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, buildType := range buildTypes.BuildTypes {
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeInfo,
+			prometheus.GaugeValue,
+			1,
+			buildType.ID, buildType.ProjectID, buildType.Name,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypePaused,
+			prometheus.GaugeValue,
+			float64(map[bool]int{true: 1, false: 0}[buildType.Paused]),
+			buildType.ID,
+		)
+
+		hasVCSTrigger := buildType.HasVCSTrigger()
+		if !hasVCSTrigger {
+			atomic.AddInt64(&scrape.withoutVCSTrigger, 1)
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeHasVCSTrigger,
+			prometheus.GaugeValue,
+			float64(map[bool]int{true: 1, false: 0}[hasVCSTrigger]),
+			buildType.ID,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeParameters,
+			prometheus.GaugeValue,
+			float64(buildType.Parameters.Count),
+			buildType.ID,
+		)
+
+		if viper.GetBool("deployments.enabled") && buildType.IsDeployment() {
+			err := collector.collectDeploymentMetrics(ctx, buildType, ch)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"build_type": buildType.ID}).Error(err)
+				countScrapeError(collector.server, "projects")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (collector *TeamCityProjectsCollector) collectDeploymentMetrics(ctx context.Context, buildType BuildType, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/rest/builds?locator=count:1,buildType:(id:%s),state:finished&fields=count,build(id,status)",
+		collector.addr,
+		buildType.ID,
+	)
+
+	builds := BuildResponse{}
+	err := tcapi.GetJSON(ctx, collector.api.HTTPClient, url, &builds)
+	if err != nil {
+		return err
+	}
+
+	// Deployment build types that never finished a build have no status to report.
+	if len(builds.Builds) == 0 {
+		return nil
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		collector.deploymentStatus,
+		prometheus.GaugeValue,
+		float64(tcapi.ParseBuildStatus(builds.Builds[0].Status)),
+		buildType.ID, buildType.Parameters.Get(viper.GetString("deployments.environment_parameter")),
+	)
+
+	return nil
+}
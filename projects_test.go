@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProjectsCollector(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root"})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds":               `{"count": 1, "build": [{"id": 7, "buildTypeId": "Team_Build"}]}`,
+		"/app/rest/buildTypes":           `{"count": 0}`,
+		"/app/rest/buildTypes?(id:Team)": fixture(t, "build_types.json"),
+	}))
+	projects := projectTree("Team")
+	projects["Team"].BuildTypes = teamcity.BuildTypeReferences{
+		Count: 2,
+		Items: []*teamcity.BuildTypeReference{{ID: "Team_Build"}, {ID: "Team_Nightly"}},
+	}
+	projects["_Root"].ChildProjects.Count = 1
+	server.API.Projects = projects
+
+	expected := `
+# HELP teamcity_build_type_favorite Whether a TeamCity build type has a build marked as favorite.
+# TYPE teamcity_build_type_favorite gauge
+teamcity_build_type_favorite{build_type_id="Team_Build"} 1
+teamcity_build_type_favorite{build_type_id="Team_Nightly"} 0
+# HELP teamcity_build_type_has_vcs_trigger Whether a TeamCity build type has a VCS trigger.
+# TYPE teamcity_build_type_has_vcs_trigger gauge
+teamcity_build_type_has_vcs_trigger{build_type_id="Team_Build"} 1
+teamcity_build_type_has_vcs_trigger{build_type_id="Team_Nightly"} 0
+# HELP teamcity_build_type_info Information about a TeamCity build type.
+# TYPE teamcity_build_type_info gauge
+teamcity_build_type_info{build_type_id="Team_Build",name="Build",project_id="Team"} 1
+teamcity_build_type_info{build_type_id="Team_Nightly",name="Nightly",project_id="Team"} 1
+# HELP teamcity_build_type_parameters_total The total number of configuration parameters of a TeamCity build type.
+# TYPE teamcity_build_type_parameters_total gauge
+teamcity_build_type_parameters_total{build_type_id="Team_Build"} 3
+teamcity_build_type_parameters_total{build_type_id="Team_Nightly"} 0
+# HELP teamcity_build_type_paused Whether a TeamCity build type is paused.
+# TYPE teamcity_build_type_paused gauge
+teamcity_build_type_paused{build_type_id="Team_Build"} 0
+teamcity_build_type_paused{build_type_id="Team_Nightly"} 1
+# HELP teamcity_build_types_without_vcs_trigger_total The total number of TeamCity build types without a VCS trigger.
+# TYPE teamcity_build_types_without_vcs_trigger_total gauge
+teamcity_build_types_without_vcs_trigger_total 1
+# HELP teamcity_project_build_types_total The total number of build types for a TeamCity project.
+# TYPE teamcity_project_build_types_total gauge
+teamcity_project_build_types_total{project_id="Team",project_name="Team"} 2
+teamcity_project_build_types_total{project_id="_Root",project_name="<Root project>"} 0
+# HELP teamcity_projects_total The total number of subprojects for a TeamCity project.
+# TYPE teamcity_projects_total gauge
+teamcity_projects_total{project_id="Team",project_name="Team"} 0
+teamcity_projects_total{project_id="_Root",project_name="<Root project>"} 1
+`
+	err := testutil.CollectAndCompare(NewTeamCityProjectsCollector(server), strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFavoriteBuildTypes(t *testing.T) {
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds": `{"count": 1, "build": [{"id": 1, "buildTypeId": "Unstarred"}]}`,
+		"/app/rest/builds?condition:(value:.teamcity.star)": `{"count": 2, "build": [{"id": 2, "buildTypeId": "A"}, {"id": 3, "buildTypeId": "A"}]}`,
+	}))
+
+	favorites, err := NewTeamCityProjectsCollector(server).favoriteBuildTypes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favorites) != 1 || !favorites["A"] {
+		t.Errorf("favoriteBuildTypes() = %v, want the build type of the starred builds alone", favorites)
+	}
+}
+
+func TestProjectsCollectorDeployments(t *testing.T) {
+	setConfig(t, map[string]interface{}{
+		"root.project.id":                   "_Root",
+		"deployments.enabled":               true,
+		"deployments.environment_parameter": "env.DEPLOYMENT_ENVIRONMENT",
+	})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds":                            `{"count": 0}`,
+		"/app/rest/builds?buildType:(id:Team_Build)":  `{"count": 1, "build": [{"id": 1, "status": "SUCCESS"}]}`,
+		"/app/rest/builds?buildType:(id:Team_Deploy)": `{"count": 1, "build": [{"id": 2, "status": "FAILURE"}]}`,
+		"/app/rest/buildTypes":                        `{"count": 0}`,
+		"/app/rest/buildTypes?(id:Team)":              fixture(t, "deployment_build_types.json"),
+	}))
+	server.API.Projects = projectTree("Team")
+
+	// Only the deployment build type reports a status, labeled with its environment.
+	expected := `
+# HELP teamcity_deployment_status The status of the latest finished build of a TeamCity deployment build type.
+# TYPE teamcity_deployment_status gauge
+teamcity_deployment_status{build_type_id="Team_Deploy",environment="production"} 2
+`
+	err := testutil.CollectAndCompare(NewTeamCityProjectsCollector(server), strings.NewReader(expected), "teamcity_deployment_status")
+	if err != nil {
+		t.Error(err)
+	}
+}
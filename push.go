@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+// NewPusher returns a pusher sending everything registered with the default registry to the configured Pushgateway,
+// grouped under the configured job.
+func NewPusher() *push.Pusher {
+	pusher := push.New(viper.GetString("push.url"), viper.GetString("push.job")).Gatherer(prometheus.DefaultGatherer)
+	if username := viper.GetString("push.username"); username != "" {
+		pusher = pusher.BasicAuth(username, viper.GetString("push.password"))
+	}
+	return pusher
+}
+
+// PushMetrics collects and pushes the metrics once when no interval is given, e.g. when running as a cron job, and
+// otherwise keeps pushing on every interval until the context is done.
+func PushMetrics(ctx context.Context, pusher *push.Pusher, interval time.Duration) error {
+	logger := logrus.WithFields(logrus.Fields{"url": viper.GetString("push.url"), "job": viper.GetString("push.job")})
+
+	if interval <= 0 {
+		logger.Info("pushing metrics")
+		return pusher.PushContext(ctx)
+	}
+
+	logger.WithFields(logrus.Fields{"interval": interval}).Info("pushing metrics periodically")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// A failed push is retried on the next interval, the Pushgateway keeps serving the previous push meanwhile.
+		err := pusher.PushContext(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+type QueuedBuild struct {
+	ID               uint64          `json:"id"`
+	BuildTypeID      string          `json:"buildTypeId"`
+	WaitReason       string          `json:"waitReason,omitempty"`
+	QueuedDate       TeamCityTime    `json:"queuedDate,omitempty"`
+	CompatibleAgents *AgentsResponse `json:"compatibleAgents,omitempty"`
+}
+
+// UnmetRequirements reports whether no agent is compatible with the queued build, meaning it can never start.
+func (build QueuedBuild) UnmetRequirements() bool {
+	return build.CompatibleAgents != nil && build.CompatibleAgents.Count == 0
+}
+
+// Pools returns the agent pools holding agents compatible with the queued build, each pool once.
+func (build QueuedBuild) Pools() []tcapi.AgentPoolReference {
+	if build.CompatibleAgents == nil {
+		return nil
+	}
+
+	pools := []tcapi.AgentPoolReference{}
+	seen := map[uint64]bool{}
+	for _, agent := range build.CompatibleAgents.Agents {
+		if agent.Pool == nil || seen[agent.Pool.ID] {
+			continue
+		}
+		seen[agent.Pool.ID] = true
+		pools = append(pools, *agent.Pool)
+	}
+	return pools
+}
+
+// Queue wait reasons, derived from the free-text wait reason TeamCity gives for queued builds.
+const (
+	QueueReasonNoCompatibleAgents = "no_compatible_agents"
+	QueueReasonNoIdleAgents       = "no_idle_agents"
+	QueueReasonDependencies       = "waiting_for_dependencies"
+	QueueReasonOther              = "other"
+)
+
+// Reason classifies why the queued build is waiting. TeamCity only describes it in English prose, e.g. "There are no
+// idle compatible agents which can run this build", so the classification relies on its wording.
+func (build QueuedBuild) Reason() string {
+	reason := strings.ToLower(build.WaitReason)
+	switch {
+	case build.UnmetRequirements() || strings.Contains(reason, "no compatible agents"):
+		return QueueReasonNoCompatibleAgents
+	case strings.Contains(reason, "no idle compatible agents") || strings.Contains(reason, "no idle agents"):
+		return QueueReasonNoIdleAgents
+	case strings.Contains(reason, "dependencies"):
+		return QueueReasonDependencies
+	}
+	return QueueReasonOther
+}
+
+type QueueResponse struct {
+	Page
+	Builds []QueuedBuild `json:"build"`
+}
+
+type TeamCityQueueCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+	now    func() time.Time
+
+	queueUnmetRequirements *prometheus.Desc
+	queueLength            *prometheus.Desc
+	buildTypeQueued        *prometheus.Desc
+	buildTypeQueueReasons  *prometheus.Desc
+	buildTypeQueueWait     *prometheus.Desc
+	oldestQueuedBuildAge   *prometheus.Desc
+	poolQueued             *prometheus.Desc
+}
+
+func NewTeamCityQueueCollector(server *Server) *TeamCityQueueCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityQueueCollector{
+		// Set the TeamCity client and address, and the clock used to age queued builds.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+		now:    time.Now,
+
+		// Queue metric descriptions.
+		queueUnmetRequirements: prometheus.NewDesc(
+			"teamcity_build_queue_unmet_requirements",
+			"Whether a queued TeamCity build has no compatible agents to run on.",
+			[]string{"build_type_id", "build_id"},
+			constLabels,
+		),
+		queueLength: prometheus.NewDesc(
+			"teamcity_queue_length",
+			"The number of builds in the TeamCity build queue.",
+			[]string{},
+			constLabels,
+		),
+		buildTypeQueued: prometheus.NewDesc(
+			"teamcity_build_type_queued_total",
+			"The total number of builds of a TeamCity build type in the build queue.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+		buildTypeQueueReasons: prometheus.NewDesc(
+			"teamcity_queue_builds",
+			"The number of builds of a TeamCity build type in the build queue by the reason they are waiting.",
+			[]string{"build_type_id", "reason"},
+			constLabels,
+		),
+		buildTypeQueueWait: prometheus.NewDesc(
+			"teamcity_queued_build_wait_seconds",
+			"The time the longest waiting queued build of a TeamCity build type has spent in the build queue.",
+			[]string{"build_type_id"},
+			constLabels,
+		),
+		oldestQueuedBuildAge: prometheus.NewDesc(
+			"teamcity_queue_oldest_build_age_seconds",
+			"The time the oldest build in the TeamCity build queue has spent in it.",
+			[]string{},
+			constLabels,
+		),
+		poolQueued: prometheus.NewDesc(
+			"teamcity_queue_pool_builds",
+			"The number of builds in the TeamCity build queue that the agents of an agent pool can run.",
+			[]string{"pool_id", "pool_name"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.queueUnmetRequirements
+	ch <- collector.queueLength
+	ch <- collector.buildTypeQueued
+	ch <- collector.buildTypeQueueReasons
+	ch <- collector.buildTypeQueueWait
+	ch <- collector.oldestQueuedBuildAge
+	ch <- collector.poolQueued
+}
+
+func (collector TeamCityQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity queue metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectQueueMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "queue")
+	}
+}
+
+func (collector *TeamCityQueueCollector) collectQueueMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// The pools of the compatible agents are only needed for the per pool queue depth.
+	compatibleAgents := "count"
+	if viper.GetBool("queue.per_pool") {
+		compatibleAgents = "count,agent(id,pool(id,name))"
+	}
+	url := fmt.Sprintf(
+		"%s/app/rest/buildQueue?locator=count:%d&fields=count,nextHref,build(id,buildTypeId,waitReason,queuedDate,compatibleAgents(%s))",
+		collector.addr,
+		viper.GetUint("page.count"),
+		compatibleAgents,
+	)
+
+	// Follow the next page links until all queued builds are gathered.
+	queue := QueueResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page QueueResponse) {
+		queue.Count = page.Count
+		queue.Builds = append(queue.Builds, page.Builds...)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(queue.Builds)}).Info("found queued builds")
+
+	// Set the queue length metric, an empty queue still reports zero.
+	ch <- prometheus.MustNewConstMetric(
+		collector.queueLength,
+		prometheus.GaugeValue,
+		float64(len(queue.Builds)),
+	)
+
+	now := collector.now()
+	queued := map[string]int{}
+	reasons := map[[2]string]int{}
+	pools := map[tcapi.AgentPoolReference]int{}
+	waits := map[string]time.Duration{}
+	oldest := time.Duration(0)
+	for _, build := range queue.Builds {
+		queued[build.BuildTypeID]++
+		reasons[[2]string{build.BuildTypeID, build.Reason()}]++
+		for _, pool := range build.Pools() {
+			pools[pool]++
+		}
+
+		// Track the longest wait per build type and overall, builds missing their queued date are skipped.
+		if !build.QueuedDate.IsZero() {
+			wait := now.Sub(build.QueuedDate.Time)
+			if wait > waits[build.BuildTypeID] {
+				waits[build.BuildTypeID] = wait
+			}
+			if wait > oldest {
+				oldest = wait
+			}
+		}
+
+		// Set the unmet requirements metric, only builds without any compatible agent are reported.
+		if build.UnmetRequirements() {
+			ch <- prometheus.MustNewConstMetric(
+				collector.queueUnmetRequirements,
+				prometheus.GaugeValue,
+				1,
+				build.BuildTypeID, fmt.Sprintf("%d", build.ID),
+			)
+		}
+	}
+
+	// Set the queue wait metric for each build type with queued builds.
+	for buildType, wait := range waits {
+		ch <- prometheus.MustNewConstMetric(
+			collector.buildTypeQueueWait,
+			prometheus.GaugeValue,
+			wait.Seconds(),
+			buildType,
+		)
+	}
+
+	// Set the oldest queued build metric, an empty queue reports zero.
+	ch <- prometheus.MustNewConstMetric(
+		collector.oldestQueuedBuildAge,
+		prometheus.GaugeValue,
+		oldest.Seconds(),
+	)
+
+	// Set the queue depth metric for each agent pool able to run queued builds.
+	for pool, count := range pools {
+		ch <- prometheus.MustNewConstMetric(
+			collector.poolQueued,
+			prometheus.GaugeValue,
+			float64(count),
+			fmt.Sprintf("%d", pool.ID), pool.Name,
+		)
+	}
+
+	// Set the queue depth metrics for each build type with queued builds, overall and by wait reason.
+	if viper.GetBool("queue.per_build_type") {
+		for buildType, count := range queued {
+			ch <- prometheus.MustNewConstMetric(
+				collector.buildTypeQueued,
+				prometheus.GaugeValue,
+				float64(count),
+				buildType,
+			)
+		}
+	}
+	if viper.GetBool("queue.per_reason") {
+		for key, count := range reasons {
+			ch <- prometheus.MustNewConstMetric(
+				collector.buildTypeQueueReasons,
+				prometheus.GaugeValue,
+				float64(count),
+				key[:]...,
+			)
+		}
+	}
+
+	return nil
+}
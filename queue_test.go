@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueueCollectorPools(t *testing.T) {
+	setConfig(t, map[string]interface{}{"queue.per_pool": true})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/buildQueue": `{"count": 3, "build": [
+			{"id": 1, "buildTypeId": "A", "compatibleAgents": {"count": 2, "agent": [
+				{"id": 1, "pool": {"id": 0, "name": "Default"}}, {"id": 2, "pool": {"id": 0, "name": "Default"}}
+			]}},
+			{"id": 2, "buildTypeId": "B", "compatibleAgents": {"count": 2, "agent": [
+				{"id": 1, "pool": {"id": 0, "name": "Default"}}, {"id": 3, "pool": {"id": 1, "name": "Linux"}}
+			]}},
+			{"id": 3, "buildTypeId": "C", "compatibleAgents": {"count": 0}}
+		]}`,
+	}))
+
+	expected := `
+# HELP teamcity_queue_pool_builds The number of builds in the TeamCity build queue that the agents of an agent pool can run.
+# TYPE teamcity_queue_pool_builds gauge
+teamcity_queue_pool_builds{pool_id="0",pool_name="Default"} 2
+teamcity_queue_pool_builds{pool_id="1",pool_name="Linux"} 1
+`
+	err := testutil.CollectAndCompare(NewTeamCityQueueCollector(server), strings.NewReader(expected), "teamcity_queue_pool_builds")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueCollectorLength(t *testing.T) {
+	setConfig(t, map[string]interface{}{"queue.per_build_type": true})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/buildQueue": `{"count": 3, "build": [
+			{"id": 1, "buildTypeId": "A"}, {"id": 2, "buildTypeId": "A"}, {"id": 3, "buildTypeId": "B"}
+		]}`,
+	}))
+
+	expected := `
+# HELP teamcity_build_type_queued_total The total number of builds of a TeamCity build type in the build queue.
+# TYPE teamcity_build_type_queued_total gauge
+teamcity_build_type_queued_total{build_type_id="A"} 2
+teamcity_build_type_queued_total{build_type_id="B"} 1
+# HELP teamcity_queue_length The number of builds in the TeamCity build queue.
+# TYPE teamcity_queue_length gauge
+teamcity_queue_length 3
+`
+	err := testutil.CollectAndCompare(NewTeamCityQueueCollector(server), strings.NewReader(expected), "teamcity_queue_length", "teamcity_build_type_queued_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueCollectorEmptyQueue(t *testing.T) {
+	server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/buildQueue": `{"count": 0}`}))
+
+	expected := `
+# HELP teamcity_queue_length The number of builds in the TeamCity build queue.
+# TYPE teamcity_queue_length gauge
+teamcity_queue_length 0
+`
+	err := testutil.CollectAndCompare(NewTeamCityQueueCollector(server), strings.NewReader(expected), "teamcity_queue_length")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueCollectorUnmetRequirements(t *testing.T) {
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/buildQueue": `{"count": 3, "build": [
+			{"id": 1, "buildTypeId": "A", "compatibleAgents": {"count": 1, "agent": [{"id": 1, "pool": {"id": 0, "name": "Default"}}]}},
+			{"id": 2, "buildTypeId": "B", "waitReason": "There are no compatible agents which can run this build", "compatibleAgents": {"count": 0}},
+			{"id": 3, "buildTypeId": "C"}
+		]}`,
+	}))
+
+	expected := `
+# HELP teamcity_build_queue_unmet_requirements Whether a queued TeamCity build has no compatible agents to run on.
+# TYPE teamcity_build_queue_unmet_requirements gauge
+teamcity_build_queue_unmet_requirements{build_id="2",build_type_id="B"} 1
+`
+	err := testutil.CollectAndCompare(NewTeamCityQueueCollector(server), strings.NewReader(expected), "teamcity_build_queue_unmet_requirements")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueCollectorReasons(t *testing.T) {
+	setConfig(t, map[string]interface{}{"queue.per_reason": true})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/buildQueue": `{"count": 5, "build": [
+			{"id": 1, "buildTypeId": "A", "waitReason": "There are no idle compatible agents which can run this build", "compatibleAgents": {"count": 1}},
+			{"id": 2, "buildTypeId": "A", "waitReason": "There are no idle compatible agents which can run this build", "compatibleAgents": {"count": 1}},
+			{"id": 3, "buildTypeId": "A", "waitReason": "Build dependencies have not been built yet", "compatibleAgents": {"count": 1}},
+			{"id": 4, "buildTypeId": "B", "waitReason": "There are no compatible agents which can run this build", "compatibleAgents": {"count": 0}},
+			{"id": 5, "buildTypeId": "B", "waitReason": "Build queue is paused", "compatibleAgents": {"count": 1}}
+		]}`,
+	}))
+
+	// The reasons are reported without the per build type queue depth.
+	expected := `
+# HELP teamcity_queue_builds The number of builds of a TeamCity build type in the build queue by the reason they are waiting.
+# TYPE teamcity_queue_builds gauge
+teamcity_queue_builds{build_type_id="A",reason="no_idle_agents"} 2
+teamcity_queue_builds{build_type_id="A",reason="waiting_for_dependencies"} 1
+teamcity_queue_builds{build_type_id="B",reason="no_compatible_agents"} 1
+teamcity_queue_builds{build_type_id="B",reason="other"} 1
+`
+	err := testutil.CollectAndCompare(
+		NewTeamCityQueueCollector(server),
+		strings.NewReader(expected),
+		"teamcity_queue_builds",
+		"teamcity_build_type_queued_total",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueCollectorWithoutBreakdown(t *testing.T) {
+	tests := []struct {
+		queue  string
+		length string
+	}{
+		{`{"count": 2, "build": [{"id": 1, "buildTypeId": "A"}, {"id": 2, "buildTypeId": "B"}]}`, "2"},
+		{`{"count": 0}`, "0"},
+	}
+
+	for _, test := range tests {
+		server := newTestServer(t, jsonRoutes(map[string]string{"/app/rest/buildQueue": test.queue}))
+
+		// Without the per build type breakdown only the queue length is reported, an empty queue included.
+		expected := `
+# HELP teamcity_queue_length The number of builds in the TeamCity build queue.
+# TYPE teamcity_queue_length gauge
+teamcity_queue_length ` + test.length + "\n"
+		err := testutil.CollectAndCompare(
+			NewTeamCityQueueCollector(server),
+			strings.NewReader(expected),
+			"teamcity_queue_length",
+			"teamcity_build_type_queued_total",
+		)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// wideProjectTree returns a project tree below the root project with width subprojects on each of depth levels.
+func wideProjectTree(width int, depth int) fakeProjects {
+	projects := fakeProjects{"_Root": &teamcity.Project{ID: "_Root", Name: "<Root project>"}}
+	parents := []string{"_Root"}
+	for level := 0; level < depth; level++ {
+		children := []string{}
+		for _, parent := range parents {
+			for i := 0; i < width; i++ {
+				child := fmt.Sprintf("%s_%d", parent, i)
+				projects[parent].ChildProjects.Items = append(
+					projects[parent].ChildProjects.Items,
+					&teamcity.ProjectReference{ID: child, Name: child},
+				)
+				projects[child] = &teamcity.Project{ID: child, Name: child, ParentProjectID: parent}
+				children = append(children, child)
+			}
+		}
+		parents = children
+	}
+	return projects
+}
+
+// collectConcurrently runs several collections of each collector at once and drains their metrics.
+func collectConcurrently(collectors []prometheus.Collector, times int) {
+	wg := sync.WaitGroup{}
+	for _, collector := range collectors {
+		for i := 0; i < times; i++ {
+			wg.Add(1)
+			go func(collector prometheus.Collector) {
+				defer wg.Done()
+				ch := make(chan prometheus.Metric)
+				go func() {
+					collector.Collect(ch)
+					close(ch)
+				}()
+				for range ch {
+				}
+			}(collector)
+		}
+	}
+	wg.Wait()
+}
+
+// TestConcurrentCollections runs overlapping collections of the collectors walking the project tree with a high
+// concurrency, run it with the race detector to catch unsynchronized shared state.
+func TestConcurrentCollections(t *testing.T) {
+	setConfig(t, map[string]interface{}{
+		"root.project.id":      "_Root",
+		"builds.since":         "24h",
+		"builds.incremental":   true,
+		"builds.per_type":      1,
+		"builds.history_limit": 2,
+	})
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/builds":     fixture(t, "builds.json"),
+		"/app/rest/buildTypes": fixture(t, "build_types.json"),
+	}))
+	server.API.Projects = wideProjectTree(6, 2)
+
+	builds := NewTeamCityBuildsCollector(server)
+	builds.semaphore = NewSemaphore(16)
+	projects := NewTeamCityProjectsCollector(server)
+	projects.semaphore = NewSemaphore(16)
+	cached := NewCachedCollector(server.Name, "builds", builds, 0, 0)
+
+	collectConcurrently([]prometheus.Collector{builds, projects, cached}, 4)
+}
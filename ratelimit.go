@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitedTransport holds requests back so that no more than the configured number of requests per second reach
+// TeamCity, allowing short bursts of up to the burst size. Requests waiting on the limit give up once their context is
+// done.
+type RateLimitedTransport struct {
+	transport http.RoundTripper
+	interval  time.Duration
+	burst     float64
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimitedTransport(transport http.RoundTripper, rate float64, burst int) *RateLimitedTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimitedTransport{
+		transport: transport,
+		interval:  time.Duration(float64(time.Second) / rate),
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+func (limited *RateLimitedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if wait := limited.reserve(); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		}
+	}
+
+	return limited.transport.RoundTrip(request)
+}
+
+// reserve takes a token from the bucket and returns how long to wait until it is available. The token stays taken
+// when the request gives up waiting, which only delays the requests after it.
+func (limited *RateLimitedTransport) reserve() time.Duration {
+	limited.mutex.Lock()
+	defer limited.mutex.Unlock()
+
+	now := time.Now()
+	limited.tokens += float64(now.Sub(limited.last)) / float64(limited.interval)
+	if limited.tokens > limited.burst {
+		limited.tokens = limited.burst
+	}
+	limited.last = now
+
+	limited.tokens--
+	if limited.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limited.tokens * float64(limited.interval))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedTransport(t *testing.T) {
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fake.Close()
+	client := &http.Client{Transport: NewRateLimitedTransport(nil, 20, 2)}
+
+	// The burst goes through at once, the two requests after it wait 50ms each for a token.
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		response, err := client.Get(fake.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("4 requests took %s, want the requests past the burst held back", elapsed)
+	}
+}
+
+func TestRateLimitedTransportGivesUp(t *testing.T) {
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fake.Close()
+	client := &http.Client{Transport: NewRateLimitedTransport(nil, 0.1, 1)}
+
+	response, err := client.Get(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	// The next token is 10s away, the request gives up once its context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "GET", fake.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = client.Do(request)
+	if err == nil || time.Since(start) > time.Second {
+		t.Errorf("request waiting on the rate limit returned %v after %s, want it to give up", err, time.Since(start))
+	}
+}
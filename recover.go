@@ -0,0 +1,31 @@
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+)
+
+var collectorPanics = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "teamcity_exporter_collector_panics_total",
+		Help: "The total number of panics recovered from while running a collector.",
+	},
+	[]string{"server", "collector"},
+)
+
+// recoverCollectorPanic keeps a panicking collector from crashing the exporter. It must be deferred directly by every
+// goroutine running collection code, as a panic can only be recovered from the goroutine it happened in.
+func recoverCollectorPanic(server string, collector string) {
+	if r := recover(); r != nil {
+		logrus.WithFields(logrus.Fields{
+			"server":    server,
+			"collector": collector,
+			"panic":     r,
+			"stack":     string(debug.Stack()),
+		}).Error("recovered from collector panic")
+		collectorPanics.WithLabelValues(server, collector).Inc()
+		countScrapeError(server, collector)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// panickingCollector panics on every collection, like a collector reading a nil map.
+type panickingCollector struct {
+	desc *prometheus.Desc
+}
+
+func (collector panickingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.desc
+}
+
+func (collector panickingCollector) Collect(ch chan<- prometheus.Metric) {
+	var builds map[string]*Build
+	ch <- prometheus.MustNewConstMetric(collector.desc, prometheus.GaugeValue, float64(builds["missing"].ID))
+}
+
+func TestCollectorPanicRecovered(t *testing.T) {
+	healthy := newGatedCollector("teamcity_test_healthy")
+	close(healthy.gate)
+	broken := panickingCollector{prometheus.NewDesc("teamcity_test_broken", "A metric never emitted.", nil, nil)}
+	panics := collectorPanics.WithLabelValues("test", "broken")
+	before := testutil.ToFloat64(panics)
+
+	composite := NewCompositeCollector([]*CachedCollector{
+		NewCachedCollector("test", "broken", broken, 0, 0),
+		NewCachedCollector("test", "healthy", healthy, 0, 0),
+	}, 0)
+
+	expected := `
+# HELP teamcity_test_healthy The number of collections so far.
+# TYPE teamcity_test_healthy gauge
+teamcity_test_healthy 1
+`
+	err := testutil.CollectAndCompare(composite, strings.NewReader(expected), "teamcity_test_healthy", "teamcity_test_broken")
+	if err != nil {
+		t.Error(err)
+	}
+	if got := testutil.ToFloat64(panics) - before; got != 1 {
+		t.Errorf("teamcity_exporter_collector_panics_total increased by %v, want 1", got)
+	}
+}
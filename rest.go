@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/cvbarros/go-teamcity/teamcity"
+	viper "github.com/spf13/viper"
+)
+
+// authModes are the accepted values of the auth.mode configuration, an empty mode picks one from the credentials.
+var authModes = []string{"", "token", "basic", "guest"}
+
+// ValidateAuthMode returns an error for an unknown authentication mode.
+func ValidateAuthMode(mode string) error {
+	for _, known := range authModes {
+		if mode == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown auth mode %q, expected one of token, basic, or guest", mode)
+}
+
+// AuthScheme returns the authentication scheme used against the server. Without an explicit auth mode a token is
+// preferred over a username.
+func (server *Server) AuthScheme() string {
+	if server.AuthMode != "" {
+		return server.AuthMode
+	}
+	if server.Token == "" && server.Username != "" {
+		return "basic"
+	}
+	return "token"
+}
+
+// AuthMethod returns the go-teamcity authentication method matching the server's authentication scheme. go-teamcity
+// has no guest authentication, guest servers get an empty token that the authorized transport strips again.
+func (server *Server) AuthMethod() teamcity.Auth {
+	if server.AuthScheme() == "basic" {
+		return teamcity.BasicAuth(server.Username, server.Password)
+	}
+	return teamcity.TokenAuth(server.Token)
+}
+
+// setAuthorization authenticates a TeamCity REST API request with the server's scheme. Guest requests carry no
+// credentials, TeamCity serves them under the /guestAuth prefix instead.
+func (server *Server) setAuthorization(request *http.Request) {
+	switch server.AuthScheme() {
+	case "basic":
+		request.SetBasicAuth(server.Username, server.Password)
+	case "guest":
+		request.Header.Del("Authorization")
+		path := request.URL.Path
+		if i := strings.Index(path, "/app/"); i >= 0 && !strings.Contains(path, "/guestAuth/") {
+			request.URL.Path = path[:i] + "/guestAuth" + path[i:]
+		}
+	default:
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", server.Token))
+	}
+}
+
+// AuthorizedTransport authenticates every request made through it against a server, so that the raw collector
+// requests of each server carry that server's credentials.
+type AuthorizedTransport struct {
+	server    *Server
+	transport http.RoundTripper
+}
+
+func NewAuthorizedTransport(server *Server, transport http.RoundTripper) *AuthorizedTransport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &AuthorizedTransport{server: server, transport: transport}
+}
+
+func (authorized *AuthorizedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// Round trippers must not modify the request they are given.
+	request = request.Clone(request.Context())
+	authorized.server.setAuthorization(request)
+	return authorized.transport.RoundTrip(request)
+}
+
+// collectionContext is the parent of every collection's context, main cancels it once the shutdown grace period ran
+// out so the remaining TeamCity requests do not outlive the exporter.
+var collectionContext, cancelCollections = context.WithCancel(context.Background())
+
+// scrapeContext returns the context bounding the TeamCity requests of a single collection, a zero scrape timeout
+// leaves them unbounded.
+func scrapeContext() (context.Context, context.CancelFunc) {
+	if timeout := viper.GetDuration("scrape.timeout"); timeout > 0 {
+		return context.WithTimeout(collectionContext, timeout)
+	}
+	return context.WithCancel(collectionContext)
+}
+
+// The TeamCity REST API types shared by the collectors live in the tcapi package, the aliases keep their names short.
+type (
+	Page              = tcapi.Page
+	TeamCityTime      = tcapi.Time
+	Build             = tcapi.Build
+	BuildResponse     = tcapi.BuildResponse
+	BuildType         = tcapi.BuildType
+	Properties        = tcapi.Properties
+	Property          = tcapi.Property
+	Agent             = tcapi.Agent
+	AgentsResponse    = tcapi.AgentsResponse
+	User              = tcapi.User
+	ProblemOccurrence = tcapi.ProblemOccurrence
+)
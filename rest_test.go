@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuthScheme(t *testing.T) {
+	tests := []struct {
+		name   string
+		server Server
+		want   string
+	}{
+		{"token", Server{Token: "secret"}, "token"},
+		{"username", Server{Username: "user", Password: "pass"}, "basic"},
+		{"token preferred over username", Server{Token: "secret", Username: "user", Password: "pass"}, "token"},
+		{"no credentials", Server{}, "token"},
+		{"explicit mode", Server{Token: "secret", Username: "user", AuthMode: "basic"}, "basic"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.server.AuthScheme(); got != test.want {
+				t.Errorf("AuthScheme() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthMethod(t *testing.T) {
+	servers := []Server{
+		{Token: "secret"},
+		{Username: "user", Password: "pass"},
+		{AuthMode: "guest"},
+	}
+
+	for _, server := range servers {
+		_, err := teamcity.NewClientWithAddress(server.AuthMethod(), "http://teamcity", http.DefaultClient)
+		if err != nil {
+			t.Errorf("%s: %s", server.AuthScheme(), err)
+		}
+	}
+}
+
+func TestAuthorizedTransport(t *testing.T) {
+	var header http.Header
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer fake.Close()
+
+	tests := []struct {
+		name   string
+		server Server
+		want   string
+	}{
+		{"token", Server{Token: "secret"}, "Bearer secret"},
+		{"basic", Server{Username: "user", Password: "pass"}, "Basic dXNlcjpwYXNz"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &http.Client{Transport: NewAuthorizedTransport(&test.server, nil)}
+			response, err := client.Get(fake.URL + "/app/rest/server")
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if got := header.Get("Authorization"); got != test.want {
+				t.Errorf("Authorization = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGuestAuthentication(t *testing.T) {
+	var path, authorization string
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "_Root", "name": "<Root project>"}`)
+	}))
+	defer fake.Close()
+
+	server := &Server{Addr: fake.URL, AuthMode: "guest"}
+	httpClient := &http.Client{Transport: NewAuthorizedTransport(server, nil)}
+	client, err := teamcity.NewClientWithAddress(server.AuthMethod(), server.Addr, httpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := client.Projects.GetByID("_Root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.ID != "_Root" {
+		t.Errorf("project ID = %q, want _Root", project.ID)
+	}
+	if path != "/guestAuth/app/rest/projects/id:_Root" {
+		t.Errorf("path = %q, want the /guestAuth prefix", path)
+	}
+	if authorization != "" {
+		t.Errorf("guest request carries Authorization %q", authorization)
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	setConfig(t, map[string]interface{}{"scrape.timeout": "50ms"})
+	hung := make(chan struct{})
+	defer close(hung)
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hung:
+		case <-r.Context().Done():
+		}
+	}))
+	server.Name = "hung"
+	errors := scrapeErrors.WithLabelValues(server.Name, "agents")
+	before := testutil.ToFloat64(errors)
+
+	done := make(chan struct{})
+	go func() {
+		testutil.CollectAndCount(NewTeamCityAgentCollector(server))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the collection is still waiting on the hung server past the scrape timeout")
+	}
+	if got := testutil.ToFloat64(errors) - before; got != 1 {
+		t.Errorf("teamcity_exporter_scrape_errors_total increased by %v, want 1", got)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+// deprecatedRetryKeys maps the HTTP retry configuration keys to the names they had before moving under http, which are
+// still read as deprecated aliases.
+var deprecatedRetryKeys = map[string]string{
+	"http.retry.max":       "retry.max",
+	"http.retry.wait_min":  "retry.wait_min",
+	"http.retry.wait_max":  "retry.wait_max",
+	"http.retry.log":       "retry.log",
+	"http.request_timeout": "retry.request_timeout",
+}
+
+// ApplyDeprecatedRetryKeys carries the values of the deprecated retry keys over to their new keys. They become the
+// defaults of the new keys, so that a new key set alongside its deprecated one still wins. The deprecated keys have
+// no defaults of their own, they are only set when configured.
+func ApplyDeprecatedRetryKeys() {
+	for key, deprecated := range deprecatedRetryKeys {
+		if !viper.IsSet(deprecated) {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"key": deprecated, "replacement": key}).Warn("deprecated configuration key")
+		viper.SetDefault(key, viper.Get(deprecated))
+	}
+}
+
+// retryLogger routes the logs of the retryable HTTP client into logrus at debug level, they are too chatty for any
+// other level during a retry storm.
+type retryLogger struct{}
+
+func (retryLogger) log(msg string, keysAndValues []interface{}) {
+	fields := logrus.Fields{"component": "retryablehttp"}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if key, ok := keysAndValues[i].(string); ok {
+			fields[key] = keysAndValues[i+1]
+		}
+	}
+	logrus.WithFields(fields).Debug(msg)
+}
+
+func (logger retryLogger) Error(msg string, keysAndValues ...interface{}) {
+	logger.log(msg, keysAndValues)
+}
+
+func (logger retryLogger) Info(msg string, keysAndValues ...interface{}) {
+	logger.log(msg, keysAndValues)
+}
+
+func (logger retryLogger) Debug(msg string, keysAndValues ...interface{}) {
+	logger.log(msg, keysAndValues)
+}
+
+func (logger retryLogger) Warn(msg string, keysAndValues ...interface{}) {
+	logger.log(msg, keysAndValues)
+}
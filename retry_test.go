@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	viper "github.com/spf13/viper"
+)
+
+func TestApplyDeprecatedRetryKeys(t *testing.T) {
+	setConfig(t, map[string]interface{}{"retry.max": 3, "retry.wait_min": "2s", "http.retry.wait_min": "5s"})
+	viper.SetDefault("http.retry.max", 10)
+
+	ApplyDeprecatedRetryKeys()
+
+	if got := viper.GetInt("http.retry.max"); got != 3 {
+		t.Errorf("http.retry.max = %d, want the deprecated retry.max", got)
+	}
+	if got := viper.GetDuration("http.retry.wait_min"); got != 5*time.Second {
+		t.Errorf("http.retry.wait_min = %s, want the new key to win", got)
+	}
+}
+
+func TestRequestTimeoutPerAttempt(t *testing.T) {
+	setConfig(t, map[string]interface{}{
+		"http.retry.max":       2,
+		"http.retry.wait_min":  "1ms",
+		"http.retry.wait_max":  "1ms",
+		"http.request_timeout": "100ms",
+	})
+
+	attempts := int32(0)
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}))
+
+	client, err := NewHTTPClient(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.Get(server.Addr + "/app/rest/server")
+	if err != nil {
+		t.Fatalf("the timed out attempt was not retried: %s", err)
+	}
+	response.Body.Close()
+
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("made %d attempts, want 2", got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+
+	viper "github.com/spf13/viper"
+)
+
+// Semaphore bounds how many operations, such as project collections, run concurrently.
+type Semaphore chan struct{}
+
+// NewScrapeSemaphore returns a semaphore sized by the configured scrape concurrency. The collectors of a server walking
+// its project tree share one, so that the number of projects collected at once stays within the scrape concurrency no
+// matter how many collectors run.
+func NewScrapeSemaphore() Semaphore {
+	size := viper.GetInt("scrape.concurrency")
+	if size < 1 {
+		size = viper.GetInt("concurrency")
+	}
+	return NewSemaphore(size)
+}
+
+func NewSemaphore(size int) Semaphore {
+	if size < 1 {
+		size = 1
+	}
+	return make(Semaphore, size)
+}
+
+// Acquire blocks until a slot is free and returns a function releasing it, calling that function more than once only
+// releases the slot the first time.
+func (semaphore Semaphore) Acquire() func() {
+	semaphore <- struct{}{}
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { <-semaphore })
+	}
+}
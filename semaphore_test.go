@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSemaphoreRelease(t *testing.T) {
+	semaphore := NewSemaphore(2)
+
+	release := semaphore.Acquire()
+	semaphore.Acquire()
+	release()
+	release()
+	if len(semaphore) != 1 {
+		t.Errorf("%d slots held after releasing one of two twice, want 1", len(semaphore))
+	}
+}
+
+func TestSemaphoreBoundsProjectFanOut(t *testing.T) {
+	setConfig(t, map[string]interface{}{"root.project.id": "_Root"})
+	var inflight, peak int32
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			previous := atomic.LoadInt32(&peak)
+			if current <= previous || atomic.CompareAndSwapInt32(&peak, previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+		fmt.Fprint(w, `{"count": 0}`)
+	}))
+	server.API.Projects = wideProjectTree(5, 2)
+
+	collector := NewTeamCityBuildsCollector(server)
+	collector.semaphore = NewSemaphore(3)
+	testutil.CollectAndCount(collector)
+
+	// Each project takes a single builds request while holding its slot.
+	if got := atomic.LoadInt32(&peak); got < 1 || got > 3 {
+		t.Errorf("peak of %d concurrent requests, want at most 3", got)
+	}
+}
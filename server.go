@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+)
+
+type ServerInfo struct {
+	Version     string       `json:"version"`
+	BuildNumber string       `json:"buildNumber"`
+	StartTime   TeamCityTime `json:"startTime,omitempty"`
+}
+
+type LicenseKey struct {
+	Key            string       `json:"key"`
+	Type           string       `json:"type"`
+	Valid          bool         `json:"valid"`
+	Active         bool         `json:"active"`
+	ExpirationDate TeamCityTime `json:"expirationDate,omitempty"`
+}
+
+type LicenseKeys struct {
+	Count       uint64       `json:"count"`
+	LicenseKeys []LicenseKey `json:"licenseKey"`
+}
+
+type LicensingData struct {
+	MaxAgents       int64       `json:"maxAgents"`
+	AgentsLeft      int64       `json:"agentsLeft"`
+	UnlimitedAgents bool        `json:"unlimitedAgents"`
+	LicenseKeys     LicenseKeys `json:"licenseKeys,omitempty"`
+}
+
+type TeamCityServerCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+
+	serverInfo        *prometheus.Desc
+	serverStartTime   *prometheus.Desc
+	licenseAgentsMax  *prometheus.Desc
+	licenseAgentsUsed *prometheus.Desc
+	licenseKeyExpiry  *prometheus.Desc
+
+	// Logs once that the licensing data is unavailable to the exporter's user.
+	licensingDenied *sync.Once
+}
+
+func NewTeamCityServerCollector(server *Server) *TeamCityServerCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityServerCollector{
+		// Set the TeamCity client and address.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+
+		// Server metric descriptions.
+		serverInfo: prometheus.NewDesc(
+			"teamcity_server_info",
+			"Information about the TeamCity server.",
+			[]string{"version", "build_number"},
+			constLabels,
+		),
+		serverStartTime: prometheus.NewDesc(
+			"teamcity_server_start_time",
+			"The start time of the TeamCity server.",
+			[]string{},
+			constLabels,
+		),
+		licenseAgentsMax: prometheus.NewDesc(
+			"teamcity_license_agents_max",
+			"The maximum number of agents the TeamCity licenses allow.",
+			[]string{},
+			constLabels,
+		),
+		licenseAgentsUsed: prometheus.NewDesc(
+			"teamcity_license_agents_used",
+			"The number of agents using up a TeamCity license.",
+			[]string{},
+			constLabels,
+		),
+		licenseKeyExpiry: prometheus.NewDesc(
+			"teamcity_license_key_expiration_time",
+			"The expiration time of an active TeamCity license key.",
+			[]string{"type", "key_suffix"},
+			constLabels,
+		),
+
+		licensingDenied: &sync.Once{},
+	}
+}
+
+func (collector TeamCityServerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.serverInfo
+	ch <- collector.serverStartTime
+	ch <- collector.licenseAgentsMax
+	ch <- collector.licenseAgentsUsed
+	ch <- collector.licenseKeyExpiry
+}
+
+func (collector TeamCityServerCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity server metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectServerMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "server")
+	}
+
+	err = collector.collectLicenseMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "server")
+	}
+}
+
+func (collector *TeamCityServerCollector) collectServerMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf("%s/app/rest/server?fields=version,buildNumber,startTime", collector.addr)
+
+	info := ServerInfo{}
+	err := tcapi.GetJSON(ctx, collector.api.HTTPClient, url, &info)
+	if err != nil {
+		return err
+	}
+
+	// Set the server info metric.
+	ch <- prometheus.MustNewConstMetric(
+		collector.serverInfo,
+		prometheus.GaugeValue,
+		1,
+		info.Version, info.BuildNumber,
+	)
+
+	// Set the server start time metric.
+	if !info.StartTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			collector.serverStartTime,
+			prometheus.GaugeValue,
+			float64(info.StartTime.Unix()),
+		)
+	}
+
+	return nil
+}
+
+func (collector *TeamCityServerCollector) collectLicenseMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/rest/server/licensingData?fields=maxAgents,agentsLeft,unlimitedAgents,licenseKeys(licenseKey(key,type,valid,active,expirationDate))",
+		collector.addr,
+	)
+
+	// Reading the licensing data requires the system administrator role, without it there is nothing to report. That
+	// is how most exporters are set up, so it is neither a scrape error nor worth more than a single debug message.
+	licensing := LicensingData{}
+	err := tcapi.GetJSON(ctx, collector.api.HTTPClient, url, &licensing)
+	var status *tcapi.StatusError
+	if errors.As(err, &status) && (status.StatusCode == http.StatusUnauthorized || status.StatusCode == http.StatusForbidden) {
+		collector.licensingDenied.Do(func() {
+			logrus.WithFields(logrus.Fields{"server": collector.server, "error": err}).
+				Debug("licensing data is unavailable, skipping the license metrics")
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Set the license agent metrics, unlimited licenses have no maximum to report.
+	if !licensing.UnlimitedAgents {
+		ch <- prometheus.MustNewConstMetric(
+			collector.licenseAgentsMax,
+			prometheus.GaugeValue,
+			float64(licensing.MaxAgents),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			collector.licenseAgentsUsed,
+			prometheus.GaugeValue,
+			float64(licensing.MaxAgents-licensing.AgentsLeft),
+		)
+	}
+
+	// Set the expiration metric for each active license key that expires. The key itself is a secret, so only its type
+	// and last characters tell the keys apart.
+	for _, key := range licensing.LicenseKeys.LicenseKeys {
+		if !key.Active || key.ExpirationDate.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			collector.licenseKeyExpiry,
+			prometheus.GaugeValue,
+			float64(key.ExpirationDate.Unix()),
+			key.Type, licenseKeySuffix(key.Key),
+		)
+	}
+
+	return nil
+}
+
+// licenseKeySuffix returns the last four characters of a license key.
+func licenseKeySuffix(key string) string {
+	if len(key) <= 4 {
+		return key
+	}
+	return key[len(key)-4:]
+}
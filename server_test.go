@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServerCollectorLicenseKeys(t *testing.T) {
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/server": `{"version": "2023.05", "buildNumber": "129203"}`,
+		"/app/rest/server/licensingData": `{"maxAgents": 10, "agentsLeft": 4, "licenseKeys": {"count": 1, "licenseKey": [
+			{"key": "SECRET-LICENSE-KEY-1234", "type": "enterprise", "valid": true, "active": true, "expirationDate": "20300101T000000+0000"}
+		]}}`,
+	}))
+
+	expected := `
+# HELP teamcity_license_key_expiration_time The expiration time of an active TeamCity license key.
+# TYPE teamcity_license_key_expiration_time gauge
+teamcity_license_key_expiration_time{key_suffix="1234",type="enterprise"} 1.893456e+09
+`
+	err := testutil.CollectAndCompare(NewTeamCityServerCollector(server), strings.NewReader(expected), "teamcity_license_key_expiration_time")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServerCollectorLicensingForbidden(t *testing.T) {
+	server := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/app/rest/server/licensingData" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		jsonRoutes(map[string]string{"/app/rest/server": `{"version": "2023.05", "buildNumber": "129203"}`})(w, r)
+	}))
+	server.Name = "forbidden"
+
+	count := testutil.CollectAndCount(NewTeamCityServerCollector(server))
+	if count != 1 {
+		t.Errorf("collected %d metrics, want only the server info", count)
+	}
+	if errors := scrapeErrorCount(server.Name, "server"); errors != 0 {
+		t.Errorf("counted %d scrape errors, want none for forbidden licensing data", errors)
+	}
+}
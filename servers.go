@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/cvbarros/go-teamcity/teamcity"
+	"github.com/hashicorp/go-retryablehttp"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+// Server is a TeamCity installation the exporter collects metrics from.
+type Server struct {
+	Name     string `mapstructure:"name"`
+	Addr     string `mapstructure:"addr"`
+	Token    string `mapstructure:"token"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	AuthMode string `mapstructure:"auth_mode"`
+
+	// The clients authenticated against the server, set once the server is connected.
+	Client *teamcity.Client `mapstructure:"-"`
+	API    *tcapi.Client    `mapstructure:"-"`
+
+	// The project filter and the scrape semaphore the collectors of the server share, set before they are created.
+	Filter    *ProjectFilter `mapstructure:"-"`
+	Semaphore Semaphore      `mapstructure:"-"`
+}
+
+// ConfiguredServers returns the servers of the servers configuration list, each of them needs a unique name to tell
+// their metrics apart. Without such a list the single unnamed server of the top-level configuration is returned.
+func ConfiguredServers() ([]*Server, error) {
+	if !viper.IsSet("servers") {
+		server := &Server{
+			Addr:     viper.GetString("addr"),
+			Token:    viper.GetString("token"),
+			Username: viper.GetString("username"),
+			Password: viper.GetString("password"),
+			AuthMode: viper.GetString("auth.mode"),
+		}
+		return []*Server{server}, ValidateAuthMode(server.AuthMode)
+	}
+
+	servers := []*Server{}
+	err := viper.UnmarshalKey("servers", &servers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid servers configuration: %w", err)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("the servers configuration lists no servers")
+	}
+
+	names := map[string]bool{}
+	for i, server := range servers {
+		if server.Name == "" || server.Addr == "" {
+			return nil, fmt.Errorf("server %d needs both a name and an address", i)
+		}
+		if names[server.Name] {
+			return nil, fmt.Errorf("duplicate server name %q", server.Name)
+		}
+		names[server.Name] = true
+
+		err := ValidateAuthMode(server.AuthMode)
+		if err != nil {
+			return nil, fmt.Errorf("server %s: %w", server.Name, err)
+		}
+	}
+	return servers, nil
+}
+
+// SelectServer returns the server named by the server query parameter of a request. The parameter can be left out
+// when only one server is configured.
+func SelectServer(servers []*Server, r *http.Request) (*Server, error) {
+	name := r.URL.Query().Get("server")
+	if name == "" {
+		if len(servers) == 1 {
+			return servers[0], nil
+		}
+		return nil, fmt.Errorf("several TeamCity servers are configured, select one with the server query parameter")
+	}
+
+	for _, server := range servers {
+		if server.Name == name {
+			return server, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown server %q", name)
+}
+
+// Connect builds the clients used to talk to the server, the go-teamcity client and the REST API client of the
+// collectors share one HTTP client.
+func (server *Server) Connect() error {
+	httpClient, err := NewHTTPClient(server)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"server": server.Name, "scheme": server.AuthScheme()}).Debug("selected TeamCity authentication scheme")
+	server.Client, err = teamcity.NewClientWithAddress(
+		server.AuthMethod(),
+		server.Addr,
+		httpClient,
+	)
+	if err != nil {
+		return err
+	}
+
+	server.API = tcapi.NewClient(server.Addr, httpClient, server.Client.Projects)
+	return nil
+}
+
+// NewHTTPClient builds the HTTP client used to talk to a server, with the configured retries, TLS settings, and rate
+// limit. The raw collector requests share this client with the go-teamcity client.
+func NewHTTPClient(server *Server) (*http.Client, error) {
+	retryClient := retryablehttp.NewClient()
+	retryClient.RetryMax = viper.GetInt("http.retry.max")
+	retryClient.RetryWaitMin = viper.GetDuration("http.retry.wait_min")
+	retryClient.RetryWaitMax = viper.GetDuration("http.retry.wait_max")
+	retryClient.Logger = nil
+	if viper.GetBool("http.retry.log") {
+		retryClient.Logger = retryLogger{}
+	}
+	retryClient.RequestLogHook = retryCounter(server.Name)
+
+	// The request timeout bounds each attempt on its own, a timed out attempt is retried like any other failure.
+	retryClient.HTTPClient.Timeout = viper.GetDuration("http.request_timeout")
+
+	// Apply the TLS configuration to the retry client's transport.
+	tlsConfig, err := TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport, ok := retryClient.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected TeamCity HTTP transport")
+	}
+	transport.TLSClientConfig = tlsConfig
+	if tlsConfig.InsecureSkipVerify {
+		logrus.WithFields(logrus.Fields{"server": server.Name}).Warn("TeamCity TLS certificate verification is disabled")
+	}
+
+	// Limit the rate of every request attempt, retries included, to keep large collections from flooding TeamCity.
+	if rate := viper.GetFloat64("rate_limit.requests_per_second"); rate > 0 {
+		retryClient.HTTPClient.Transport = NewRateLimitedTransport(transport, rate, viper.GetInt("rate_limit.burst"))
+	}
+
+	// Count the requests that make it out of the retry client, i.e. one per request however often it was retried, and
+	// authenticate them against the server.
+	httpClient := retryClient.StandardClient()
+	httpClient.Transport = NewAuthorizedTransport(server, NewInstrumentedTransport(server.Name, httpClient.Transport))
+	return httpClient, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+)
+
+func TestSelectServer(t *testing.T) {
+	primary, legacy := &Server{Name: "main"}, &Server{Name: "legacy"}
+
+	tests := []struct {
+		name    string
+		servers []*Server
+		query   string
+		want    *Server
+	}{
+		{"single server", []*Server{primary}, "", primary},
+		{"named server", []*Server{primary, legacy}, "?server=legacy", legacy},
+		{"unnamed among several", []*Server{primary, legacy}, "", nil},
+		{"unknown server", []*Server{primary, legacy}, "?server=other", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/collect"+test.query, nil)
+			got, err := SelectServer(test.servers, r)
+			if got != test.want {
+				t.Errorf("SelectServer() = %v, want %v", got, test.want)
+			}
+			if (err != nil) != (test.want == nil) {
+				t.Errorf("SelectServer() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestReadinessChecksEveryServer(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer down.Close()
+
+	servers := []*Server{
+		{Name: "main", Addr: up.URL, API: tcapi.NewClient(up.URL, up.Client(), nil)},
+		{Name: "legacy", Addr: down.URL, API: tcapi.NewClient(down.URL, down.Client(), nil)},
+	}
+
+	err := NewReadinessHandler(servers[:1]).check(context.Background())
+	if err != nil {
+		t.Errorf("check() = %v, want the main server to be ready", err)
+	}
+
+	err = NewReadinessHandler(servers).check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "server legacy") {
+		t.Errorf("check() = %v, want the legacy server to fail", err)
+	}
+}
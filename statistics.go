@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+// StatisticKeys parses the comma-separated list of build statistic keys to collect.
+func StatisticKeys(value string) []string {
+	keys := []string{}
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+type TeamCityStatisticsCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+	root   string
+	keys   []string
+	filter *ProjectFilter
+
+	buildStatistic *prometheus.Desc
+}
+
+func NewTeamCityStatisticsCollector(server *Server) *TeamCityStatisticsCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityStatisticsCollector{
+		// Set the TeamCity client and address, the project to collect, and the statistics to collect.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+		root:   viper.GetString("root.project.id"),
+		keys:   StatisticKeys(viper.GetString("statistics.keys")),
+		filter: server.Filter,
+
+		// Build statistic metric descriptions.
+		buildStatistic: prometheus.NewDesc(
+			"teamcity_build_statistic",
+			"The value of a statistic of the latest finished TeamCity build of a build type.",
+			[]string{"build_type_id", "build_id", "key"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityStatisticsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.buildStatistic
+}
+
+func (collector TeamCityStatisticsCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity build statistic metrics")
+
+	// Every build has dozens of statistics, only the configured ones are worth fetching.
+	if len(collector.keys) == 0 {
+		logrus.Debug("no build statistic keys configured")
+		return
+	}
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectStatisticMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "statistics")
+	}
+}
+
+func (collector *TeamCityStatisticsCollector) collectStatisticMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// Only a single page of the most recent builds is read, the statistics make every build expensive to list.
+	locator := fmt.Sprintf("affectedProject:(id:%s),state:finished,count:%d", collector.root, viper.GetUint("statistics.builds"))
+	url := fmt.Sprintf(
+		"%s/app/rest/builds?locator=%s&fields=count,build(id,buildTypeId,buildType(id,projectId),state,statistics(property(name,value)))",
+		collector.addr,
+		neturl.QueryEscape(locator),
+	)
+
+	builds := BuildResponse{}
+	err := tcapi.GetJSON(ctx, collector.api.HTTPClient, url, &builds)
+	if err != nil {
+		return err
+	}
+
+	// The builds of projects excluded by the project filter are dropped.
+	included, err := collector.filter.IncludedProjects(ctx, collector.api, collector.root)
+	if err != nil {
+		return err
+	}
+	filtered := []Build{}
+	for _, build := range builds.Builds {
+		if included == nil || included[build.BuildType.ProjectID] {
+			filtered = append(filtered, build)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(filtered)}).Info("found builds with statistics")
+	for buildType, build := range LastBuilds(filtered) {
+		// Set the statistic metric for each configured key the build reported.
+		for _, key := range collector.keys {
+			value, ok := build.Statistic(key)
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				collector.buildStatistic,
+				prometheus.GaugeValue,
+				value,
+				buildType, fmt.Sprintf("%d", build.ID), key,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+type TeamCityTemplatesCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+
+	templateInfo *prometheus.Desc
+	templates    *prometheus.Desc
+}
+
+func NewTeamCityTemplatesCollector(server *Server) *TeamCityTemplatesCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityTemplatesCollector{
+		// Set the TeamCity client and address.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+
+		// Template metric descriptions.
+		templateInfo: prometheus.NewDesc(
+			"teamcity_template_info",
+			"Information about a TeamCity build configuration template.",
+			[]string{"template_id", "template_name"},
+			constLabels,
+		),
+		templates: prometheus.NewDesc(
+			"teamcity_templates_total",
+			"The total number of TeamCity build configuration templates.",
+			[]string{},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityTemplatesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.templateInfo
+	ch <- collector.templates
+}
+
+func (collector TeamCityTemplatesCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity template metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectTemplateMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "templates")
+	}
+}
+
+func (collector *TeamCityTemplatesCollector) collectTemplateMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/rest/buildTypes?locator=count:%d,templateFlag:true&fields=count,nextHref,buildType(id,name)",
+		collector.addr,
+		viper.GetUint("page.count"),
+	)
+
+	// Follow the next page links until all templates are gathered.
+	templates := BuildTypesResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page BuildTypesResponse) {
+		templates.BuildTypes = append(templates.BuildTypes, page.BuildTypes...)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(templates.BuildTypes)}).Info("found templates")
+	for _, template := range templates.BuildTypes {
+		// Set the template info metric.
+		ch <- prometheus.MustNewConstMetric(
+			collector.templateInfo,
+			prometheus.GaugeValue,
+			1,
+			template.ID, template.Name,
+		)
+	}
+
+	// Set the template count metric.
+	ch <- prometheus.MustNewConstMetric(
+		collector.templates,
+		prometheus.GaugeValue,
+		float64(len(templates.BuildTypes)),
+	)
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTemplatesCollector(t *testing.T) {
+	server := newTestServer(t, jsonRoutes(map[string]string{
+		"/app/rest/buildTypes": `{"count": 1, "buildType": [{"id": "Team_Build", "name": "Build"}]}`,
+		"/app/rest/buildTypes?templateFlag:true": `{"count": 2, "buildType": [
+			{"id": "Team_Gradle", "name": "Gradle build"},
+			{"id": "Team_Deploy", "name": "Deploy"}
+		]}`,
+	}))
+
+	expected := `
+# HELP teamcity_template_info Information about a TeamCity build configuration template.
+# TYPE teamcity_template_info gauge
+teamcity_template_info{template_id="Team_Deploy",template_name="Deploy"} 1
+teamcity_template_info{template_id="Team_Gradle",template_name="Gradle build"} 1
+# HELP teamcity_templates_total The total number of TeamCity build configuration templates.
+# TYPE teamcity_templates_total gauge
+teamcity_templates_total 2
+`
+	err := testutil.CollectAndCompare(NewTeamCityTemplatesCollector(server), strings.NewReader(expected))
+	if err != nil {
+		t.Error(err)
+	}
+}
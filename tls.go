@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	viper "github.com/spf13/viper"
+)
+
+// TLSConfig builds the TLS configuration used to talk to TeamCity. The CA file is appended to the system cert pool so
+// servers behind an internal CA verify, and a client certificate is only loaded when both its files are set.
+func TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: viper.GetBool("tls.insecure_skip_verify"),
+	}
+
+	if file := viper.GetString("tls.ca_file"); file != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TLS CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", file)
+		}
+		config.RootCAs = pool
+	}
+
+	certFile := viper.GetString("tls.cert_file")
+	keyFile := viper.GetString("tls.key_file")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both the TLS client certificate and key files must be set")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// MetricsTLSConfig builds the TLS configuration of the metrics server, it returns nil when no certificate is set and
+// the metrics are served in plaintext. A client CA requires scrapers to present a certificate it signed.
+func MetricsTLSConfig() (*tls.Config, error) {
+	certFile := viper.GetString("metrics.tls.cert")
+	keyFile := viper.GetString("metrics.tls.key")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both the metrics TLS certificate and key files must be set")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if file := viper.GetString("metrics.tls.client_ca"); file != "" {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read metrics TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in metrics TLS client CA file %s", file)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
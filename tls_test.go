@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTLSTestServer starts a TLS server and writes its self-signed certificate to a PEM file, returning the server
+// along with the file.
+func newTLSTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	fake := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(fake.Close)
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: fake.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, file
+}
+
+func TestTLSConfig(t *testing.T) {
+	fake, caFile := newTLSTestServer(t)
+
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{"system pool", map[string]interface{}{}, true},
+		{"CA file", map[string]interface{}{"tls.ca_file": caFile}, false},
+		{"insecure", map[string]interface{}{"tls.insecure_skip_verify": true}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setConfig(t, test.config)
+			client, err := NewHTTPClient(&Server{Addr: fake.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := client.Get(fake.URL + "/app/rest/server")
+			if err == nil {
+				response.Body.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("request error = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSConfigClientCertificate(t *testing.T) {
+	_, caFile := newTLSTestServer(t)
+
+	setConfig(t, map[string]interface{}{"tls.cert_file": caFile})
+	_, err := TLSConfig()
+	if err == nil {
+		t.Error("TLSConfig() accepted a client certificate without its key")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	viper "github.com/spf13/viper"
+)
+
+// undefaultedKeys are the configuration keys without a default, as setting one would change their meaning, e.g. a
+// servers list replaces the top-level server however empty it is.
+var undefaultedKeys = []string{"servers"}
+
+// PrintUsage prints the command line flags followed by every configuration key with its environment variable and
+// value, read from viper so the list never falls behind the defaults set in main. Secrets are masked, as their values
+// may come from the environment.
+func PrintUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [flags]\n\nFlags:\n", flag.CommandLine.Name())
+	flag.CommandLine.SetOutput(w)
+	flag.PrintDefaults()
+
+	fmt.Fprintf(w, "\nConfiguration keys, set in the configuration file or through their environment variable:\n")
+	keys := viper.AllKeys()
+	for _, key := range undefaultedKeys {
+		if !viper.IsSet(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  KEY\tVARIABLE\tVALUE")
+	for _, key := range keys {
+		variable := "TEAMCITY_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		value := viper.Get(key)
+		if value == nil {
+			value = ""
+		}
+		if (strings.HasSuffix(key, "token") || strings.HasSuffix(key, "password")) && viper.GetString(key) != "" {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(table, "  %s\t%s\t%v\n", key, variable, value)
+	}
+	table.Flush()
+}
+
+// VersionString describes the build of the exporter in one line.
+func VersionString() string {
+	return fmt.Sprintf("teamcity-exporter %s (commit %s, built %s, %s)", version, commit, date, runtime.Version())
+}
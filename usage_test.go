@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintUsage(t *testing.T) {
+	setConfig(t, map[string]interface{}{"addr": "https://teamcity.example.com", "token": "secret"})
+
+	output := bytes.Buffer{}
+	PrintUsage(&output)
+
+	for _, want := range []string{"TEAMCITY_ADDR", "https://teamcity.example.com", "TEAMCITY_SERVERS", "<redacted>"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("usage lacks %q:\n%s", want, output.String())
+		}
+	}
+	if strings.Contains(output.String(), "secret") {
+		t.Errorf("usage shows the token:\n%s", output.String())
+	}
+}
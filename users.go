@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+type UsersResponse struct {
+	Page
+	Users []User `json:"user"`
+}
+
+type UserCount struct {
+	Count uint64 `json:"count"`
+}
+
+type UserGroup struct {
+	Key   string     `json:"key"`
+	Name  string     `json:"name"`
+	Users *UserCount `json:"users,omitempty"`
+}
+
+type UserGroupsResponse struct {
+	Page
+	Groups []UserGroup `json:"group"`
+}
+
+type TeamCityUsersCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+	now    func() time.Time
+
+	users            *prometheus.Desc
+	usersActive      *prometheus.Desc
+	userGroupMembers *prometheus.Desc
+}
+
+func NewTeamCityUsersCollector(server *Server) *TeamCityUsersCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityUsersCollector{
+		// Set the TeamCity client and address, and the clock used for the activity window.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+		now:    time.Now,
+
+		// User metric descriptions.
+		users: prometheus.NewDesc(
+			"teamcity_users_total",
+			"The total number of TeamCity users.",
+			[]string{},
+			constLabels,
+		),
+		usersActive: prometheus.NewDesc(
+			"teamcity_users_active",
+			"The number of TeamCity users that logged in within the users active window.",
+			[]string{},
+			constLabels,
+		),
+		userGroupMembers: prometheus.NewDesc(
+			"teamcity_user_group_members",
+			"The number of direct members of a TeamCity user group.",
+			[]string{"group_key", "name"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityUsersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.users
+	ch <- collector.usersActive
+	ch <- collector.userGroupMembers
+}
+
+func (collector TeamCityUsersCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity user metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectUserMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "users")
+	}
+
+	err = collector.collectUserGroupMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "users")
+	}
+}
+
+func (collector *TeamCityUsersCollector) collectUserMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf("%s/app/rest/users?fields=count,nextHref,user(id,lastLogin)", collector.addr)
+
+	// Follow the next page links, counting the users and those that logged in within the window.
+	since := collector.now().Add(-viper.GetDuration("users.active_window"))
+	users, active := 0, 0
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page UsersResponse) {
+		for _, user := range page.Users {
+			users++
+			if user.LastLogin.After(since) {
+				active++
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": users}).Info("found users")
+
+	// Set the user count metrics.
+	ch <- prometheus.MustNewConstMetric(
+		collector.users,
+		prometheus.GaugeValue,
+		float64(users),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		collector.usersActive,
+		prometheus.GaugeValue,
+		float64(active),
+	)
+
+	return nil
+}
+
+func (collector *TeamCityUsersCollector) collectUserGroupMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf("%s/app/rest/userGroups?fields=count,nextHref,group(key,name,users(count))", collector.addr)
+
+	// Follow the next page links until all user groups are gathered.
+	groups := UserGroupsResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page UserGroupsResponse) {
+		groups.Groups = append(groups.Groups, page.Groups...)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(groups.Groups)}).Info("found user groups")
+	for _, group := range groups.Groups {
+		members := uint64(0)
+		if group.Users != nil {
+			members = group.Users.Count
+		}
+
+		// Set the group members metric.
+		ch <- prometheus.MustNewConstMetric(
+			collector.userGroupMembers,
+			prometheus.GaugeValue,
+			float64(members),
+			group.Key, group.Name,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/celestialorb/teamcity-exporter/internal/tcapi"
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+	viper "github.com/spf13/viper"
+)
+
+type VcsCheckStatus struct {
+	Status    string       `json:"status"`
+	Timestamp TeamCityTime `json:"timestamp,omitempty"`
+}
+
+type VcsStatus struct {
+	Current  VcsCheckStatus `json:"current,omitempty"`
+	Previous VcsCheckStatus `json:"previous,omitempty"`
+}
+
+type VcsRootInstance struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	VcsRootID string    `json:"vcs-root-id"`
+	Status    VcsStatus `json:"status,omitempty"`
+}
+
+type VcsRootInstancesResponse struct {
+	Page
+	VcsRootInstances []VcsRootInstance `json:"vcs-root-instance"`
+}
+
+type TeamCityVcsRootsCollector struct {
+	api    *tcapi.Client
+	server string
+	addr   string
+
+	vcsRootInstanceStatus      *prometheus.Desc
+	vcsRootInstanceLastChecked *prometheus.Desc
+}
+
+func NewTeamCityVcsRootsCollector(server *Server) *TeamCityVcsRootsCollector {
+	constLabels := prometheus.Labels{}
+
+	return &TeamCityVcsRootsCollector{
+		// Set the TeamCity client and address.
+		api:    server.API,
+		server: server.Name,
+		addr:   server.Addr,
+
+		// VCS root metric descriptions.
+		vcsRootInstanceStatus: prometheus.NewDesc(
+			"teamcity_vcs_root_instance_status",
+			"The current checking for changes status of a TeamCity VCS root instance.",
+			[]string{"vcs_root_id", "vcs_root_instance_id", "name", "status"},
+			constLabels,
+		),
+		vcsRootInstanceLastChecked: prometheus.NewDesc(
+			"teamcity_vcs_root_instance_last_checked_timestamp",
+			"The time a TeamCity VCS root instance last changed its checking for changes status.",
+			[]string{"vcs_root_id", "vcs_root_instance_id", "name"},
+			constLabels,
+		),
+	}
+}
+
+func (collector TeamCityVcsRootsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.vcsRootInstanceStatus
+	ch <- collector.vcsRootInstanceLastChecked
+}
+
+func (collector TeamCityVcsRootsCollector) Collect(ch chan<- prometheus.Metric) {
+	logrus.Info("collecting TeamCity VCS root metrics")
+
+	ctx, cancel := scrapeContext()
+	defer cancel()
+
+	err := collector.collectVcsRootMetrics(ctx, ch)
+	if err != nil {
+		logrus.Error(err)
+		countScrapeError(collector.server, "vcs")
+	}
+}
+
+func (collector *TeamCityVcsRootsCollector) collectVcsRootMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	url := fmt.Sprintf(
+		"%s/app/rest/vcs-root-instances?locator=count:%d&fields=count,nextHref,vcs-root-instance(id,name,vcs-root-id,status(current(status,timestamp)))",
+		collector.addr,
+		viper.GetUint("page.count"),
+	)
+
+	// Follow the next page links until all VCS root instances are gathered.
+	instances := VcsRootInstancesResponse{}
+	err := tcapi.GetPages(ctx, collector.api.HTTPClient, url, func(page VcsRootInstancesResponse) {
+		instances.VcsRootInstances = append(instances.VcsRootInstances, page.VcsRootInstances...)
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{"count": len(instances.VcsRootInstances)}).Info("found VCS root instances")
+	for _, instance := range instances.VcsRootInstances {
+		labels := []string{instance.VcsRootID, instance.ID, instance.Name}
+
+		// Set the status metric, instances TeamCity has never checked report no status.
+		if instance.Status.Current.Status != "" {
+			ch <- prometheus.MustNewConstMetric(
+				collector.vcsRootInstanceStatus,
+				prometheus.GaugeValue,
+				1,
+				append(labels, instance.Status.Current.Status)...,
+			)
+		}
+
+		// Set the last checked metric.
+		if !instance.Status.Current.Timestamp.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				collector.vcsRootInstanceLastChecked,
+				prometheus.GaugeValue,
+				float64(instance.Status.Current.Timestamp.Unix()),
+				labels...,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	logrus "github.com/sirupsen/logrus"
+)
+
+// version, commit and date describe the build of the exporter, set at build time with e.g.
+// -ldflags "-X main.version=<version> -X main.commit=<commit> -X main.date=<date>".
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+var buildInfo = prometheus.NewGaugeFunc(
+	prometheus.GaugeOpts{
+		Name: "teamcity_exporter_build_info",
+		Help: "The build of the exporter, always 1.",
+		ConstLabels: prometheus.Labels{
+			"version":   version,
+			"commit":    commit,
+			"date":      date,
+			"goversion": runtime.Version(),
+		},
+	},
+	func() float64 { return 1 },
+)
+
+type versionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"goVersion"`
+}
+
+// VersionHandler serves the build of the exporter as JSON, for inventories that do not scrape the metrics.
+type VersionHandler struct{}
+
+func (handler VersionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(versionResponse{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}
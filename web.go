@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	viper "github.com/spf13/viper"
+)
+
+// RequireBasicAuth protects a handler with the optional web authentication credentials. When no username is configured
+// the handler is served as is.
+func RequireBasicAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expectedUsername := viper.GetString("web.auth.username")
+		if expectedUsername == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		usernameMatches := subtle.ConstantTimeCompare([]byte(username), []byte(expectedUsername)) == 1
+		passwordMatches := subtle.ConstantTimeCompare([]byte(password), []byte(viper.GetString("web.auth.password"))) == 1
+		if !ok || !usernameMatches || !passwordMatches {
+			w.Header().Set("WWW-Authenticate", `Basic realm="teamcity-exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}